@@ -0,0 +1,203 @@
+package upload
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	pfs "github.com/Shivam010/upload/pfsblob"
+	"gocloud.dev/blob"
+)
+
+// StaleTempAge is the age after which the temporary files of the file system
+// buckets, left behind by interrupted writes, are removed when the bucket is opened
+const StaleTempAge = time.Hour
+
+// localWrite is a write of a file system bucket, whose content the blob
+// writes into a temporary file, renamed to the file when closed. The
+// temporary file is journaled while being written, so that only the files
+// created by the bucket are removed if left behind (see removeStaleTemp), and
+// the content and its sidecar attributes are synced to the disk.
+type localWrite struct {
+	// path of the file written
+	path string
+	// journal is the directory of the journal of the bucket
+	journal string
+	// temp is the temporary file and entry its journal entry, once created
+	temp  *os.File
+	entry string
+	// modTime of the content, once synced
+	modTime time.Time
+}
+
+// prepare returns the body and options of the write
+func (l *localWrite) prepare(body io.Reader, wopts *blob.WriterOptions) (io.Reader, *blob.WriterOptions) {
+	o := &blob.WriterOptions{}
+	if wopts != nil {
+		*o = *wopts
+	}
+	// the blob writer buffers the content until it detects the content type,
+	// so it is detected beforehand, for all of the content to reach the file
+	if o.ContentType == "" {
+		body, o.ContentType = detectContentType(body)
+	}
+	before := o.BeforeWrite
+	o.BeforeWrite = func(as func(interface{}) bool) error {
+		if as(&l.temp) {
+			if err := l.track(); err != nil {
+				// the blob leaves the temporary file behind on errors
+				_ = l.temp.Close()
+				_ = os.Remove(l.temp.Name())
+				return err
+			}
+		}
+		if before != nil {
+			return before(as)
+		}
+		return nil
+	}
+	return body, o
+}
+
+// track records the temporary file in the journal
+func (l *localWrite) track() error {
+	if err := os.MkdirAll(l.journal, 0700); err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(l.temp.Name()))
+	entry := filepath.Join(l.journal, hex.EncodeToString(sum[:16]))
+	if err := ioutil.WriteFile(entry, []byte(l.temp.Name()), 0600); err != nil {
+		return err
+	}
+	l.entry = entry
+	return nil
+}
+
+// sync syncs the content written to the disk, before the blob writer is
+// closed and renames it
+func (l *localWrite) sync() error {
+	if l.temp == nil {
+		return nil
+	}
+	if err := l.temp.Sync(); err != nil {
+		return err
+	}
+	st, err := l.temp.Stat()
+	if err != nil {
+		return err
+	}
+	l.modTime = st.ModTime()
+	return nil
+}
+
+// commit syncs the sidecar attributes and the renaming of the file, once the
+// blob writer is closed. The blob writes the sidecar before renaming the
+// content, so the sidecar is given the modification time of the content,
+// which tells the readers whether both are of the same write (see
+// pfsblob.IsCurrent). As the file is already renamed, its error does not fail
+// the write.
+func (l *localWrite) commit() error {
+	sidecar := l.path + pfs.AttrsExt
+	if !l.modTime.IsZero() {
+		if err := os.Chtimes(sidecar, l.modTime, l.modTime); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := syncFile(sidecar); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return syncFile(filepath.Dir(l.path))
+}
+
+// done removes the journal entry, once the temporary file is renamed or removed
+func (l *localWrite) done() {
+	if l.entry != "" {
+		_ = os.Remove(l.entry)
+	}
+}
+
+// detectContentType detects the content type of body from its first 512
+// bytes, as the blob writer does, and returns the reader of the whole body
+func detectContentType(body io.Reader) (io.Reader, string) {
+	br := bufio.NewReaderSize(body, 512)
+	head, _ := br.Peek(512)
+	return br, http.DetectContentType(head)
+}
+
+// syncFile syncs the file or directory at path, so that its content (the
+// renaming of its files, for a directory) is durable
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// tempJournal returns the directory of the journal of the temporary files
+// being written in the file system bucket, or "" for the other buckets. It is
+// kept in the storage directory, under the staging prefix, so that it outlives
+// a reboot along with the temporary files.
+func (b *Bucket) tempJournal() string {
+	return b.localPath(b.stagingName("temp", ""))
+}
+
+// removeStaleTemp removes the temporary files of the file system bucket left
+// behind by interrupted writes, older than StaleTempAge. Only the journaled
+// files are removed, and never the ones having a sidecar, i.e. written as files.
+func (b *Bucket) removeStaleTemp(ctx context.Context) {
+	journal := b.tempJournal()
+	if journal == "" {
+		return
+	}
+	entries, err := ioutil.ReadDir(journal)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			b.logger.Log(ctx, LevelWarn, "bucket: reading the temporary files journal failed", "bucket", b.name, "error", err)
+		}
+		return
+	}
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		entry := filepath.Join(journal, e.Name())
+		if time.Since(e.ModTime()) <= StaleTempAge {
+			continue
+		}
+		temp, err := ioutil.ReadFile(entry)
+		if err != nil {
+			continue
+		}
+		path := string(temp)
+		st, err := os.Lstat(path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil || time.Since(st.ModTime()) <= StaleTempAge:
+			continue
+		case st.Mode().IsRegular() && !exists(path+pfs.AttrsExt):
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				b.logger.Log(ctx, LevelWarn, "bucket: removing stale temporary file failed", "bucket", b.name, "path", path, "error", err)
+				continue
+			}
+		}
+		_ = os.Remove(entry)
+	}
+}
+
+// exists reports whether there is a file at path
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
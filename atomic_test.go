@@ -0,0 +1,144 @@
+package upload
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pfs "github.com/Shivam010/upload/pfsblob"
+)
+
+func TestAtomicWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bucket := NewBucket("pfs://localhost" + dir)
+	if _, err := bucket.WriteAll(ctx, "one.in", []byte("old")); err != nil {
+		t.Fatalf("WriteAll(one.in), got: %v \n", err)
+	}
+
+	r, w := io.Pipe()
+	done := make(chan error)
+	go func() {
+		_, err := bucket.Upload(ctx, "one.in", r, nil)
+		done <- err
+	}()
+	if _, err := w.Write(make([]byte, 4096)); err != nil {
+		t.Fatalf("Write(), got: %v \n", err)
+	}
+	// half-written content is not visible
+	if con, err := ioutil.ReadFile(filepath.Join(dir, "one.in")); err != nil || string(con) != "old" {
+		t.Errorf("ReadFile(one.in), got: %q, %v want: old \n", con, err)
+	}
+	if _, err := w.Write(make([]byte, 4096)); err != nil {
+		t.Fatalf("Write(), got: %v \n", err)
+	}
+	_ = w.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Upload(one.in), got: %v \n", err)
+	}
+	if st, err := os.Stat(filepath.Join(dir, "one.in")); err != nil || st.Size() != 8192 {
+		t.Errorf("Stat(one.in), got: %v, %v want: 8192 bytes \n", st, err)
+	}
+	if attrs, err := bucket.Attributes(ctx, "one.in"); err != nil || attrs.ContentType != "application/octet-stream" {
+		t.Errorf("Attributes(one.in), got: %v, %v want: application/octet-stream \n", attrs, err)
+	}
+	// the sidecar is of the same write as the content
+	content, _ := os.Stat(filepath.Join(dir, "one.in"))
+	if sidecar, err := os.Stat(filepath.Join(dir, "one.in.attrs")); err != nil || !pfs.IsCurrent(content, sidecar) {
+		t.Errorf("Stat(one.in.attrs), got: %v, %v want: modified with one.in \n", sidecar, err)
+	}
+	// and the temporary file is no longer journaled, the journal being
+	// kept under the staging prefix
+	if entries, err := ioutil.ReadDir(filepath.Join(dir, ".uploads", "temp")); err != nil || len(entries) != 0 {
+		t.Errorf("ReadDir(journal), got: %v, %v want: no entries \n", len(entries), err)
+	}
+}
+
+func TestAtomicWriteOptions(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "new")
+	bucket := NewBucket("file://" + dir + "?create_dir=true")
+	if got := bucket.tempJournal(); got != filepath.Join(dir, ".uploads", "temp") {
+		t.Errorf("tempJournal(), got: %v want: %v \n", got, filepath.Join(dir, ".uploads", "temp"))
+	}
+	if _, err := bucket.WriteAll(ctx, "dir/one.in", []byte("one")); err != nil {
+		t.Fatalf("WriteAll(dir/one.in), got: %v \n", err)
+	}
+	if con, err := ioutil.ReadFile(filepath.Join(dir, "dir", "one.in")); err != nil || string(con) != "one" {
+		t.Errorf("ReadFile(dir/one.in), got: %q, %v want: one \n", con, err)
+	}
+}
+
+func TestRemoveStaleTemp(t *testing.T) {
+	old := time.Now().Add(-2 * StaleTempAge)
+	for _, url := range []string{"file://", "pfs://localhost"} {
+		dir := t.TempDir()
+		bucket := NewBucket(url + dir)
+
+		files := []struct {
+			name    string
+			recent  bool
+			journal bool
+			sidecar bool
+			removed bool
+		}{
+			{name: "dir/fileblob123", journal: true, removed: true},
+			{name: "fileblob456", journal: true, recent: true},
+			{name: "dir/one.in", journal: true, sidecar: true},
+			// files of the user named as the temporary files are kept
+			{name: "reports/fileblob2024"},
+			{name: "dir/fileblob"},
+		}
+		for _, f := range files {
+			path := filepath.Join(dir, filepath.FromSlash(f.name))
+			if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(f.name), 0666); err != nil {
+				t.Fatal(err)
+			}
+			if f.sidecar {
+				if err := ioutil.WriteFile(path+".attrs", []byte("{}"), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if f.journal {
+				temp, err := os.Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				l := &localWrite{journal: bucket.tempJournal(), temp: temp}
+				err = l.track()
+				_ = temp.Close()
+				if err != nil {
+					t.Fatalf("track(%v), got: %v \n", f.name, err)
+				}
+				if err := os.Chtimes(l.entry, old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !f.recent {
+				if err := os.Chtimes(path, old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		if err := bucket.Open(); err != nil {
+			t.Fatalf("Open(%v), got: %v \n", url, err)
+		}
+		for _, f := range files {
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f.name))); (err != nil) != f.removed {
+				t.Errorf("Open(%v), %v got: %v want removed: %v \n", url, f.name, err, f.removed)
+			}
+		}
+		// the entries of the removed and committed files are dropped
+		if entries, err := ioutil.ReadDir(bucket.tempJournal()); err != nil || len(entries) != 1 {
+			t.Errorf("Open(%v), journal got: %v, %v want: 1 entry \n", url, len(entries), err)
+		}
+	}
+}
@@ -1,13 +1,17 @@
 package upload
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/url"
 	"strings"
+	"sync"
 
+	"github.com/Shivam010/upload/internal/keylock"
 	pfs "github.com/Shivam010/upload/pfsblob"
 	"gocloud.dev/blob"
 	file "gocloud.dev/blob/fileblob"
@@ -45,6 +49,25 @@ type Bucket struct {
 	provider Provider
 	bucket   *blob.Bucket
 	metadata map[string]string
+	// openMu guards the opening of bucket, which is opened lazily by the
+	// first operation if Open has not been called
+	openMu sync.Mutex
+
+	// interceptors wrapping every operation, see Use
+	interceptors []Interceptor
+	// logger of the bucket, see SetLogger
+	logger leveledLogger
+	// default timeouts of the operations, see SetTimeouts
+	timeouts Timeouts
+	// prefix of the resumable uploads, see SetStagingPrefix
+	staging string
+	// verifyOnRead verifies the content read, see SetVerifyOnRead
+	verifyOnRead bool
+	// locks serialise the conditional writes, see WriteOptions.IfNotExists
+	locks keylock.Locks
+	// keys wrapping the data keys using the key keyID, see SetEncryption
+	keys  KeyProvider
+	keyID string
 }
 
 // NewBucket will return the blob bucket using the provided bucket url
@@ -104,6 +127,7 @@ func (b *Bucket) parse() {
 		if isSecure == "true" {
 			b.name = "https://"
 		}
+		b.metadata["secure"] = isSecure
 		b.name += u.Host
 		if route != "" {
 			b.name += "/" + route
@@ -140,11 +164,35 @@ func (b *Bucket) GetMetadata(key string) string {
 	return b.metadata[key]
 }
 
-// OpenContext opens a new bucket connection
-func (b *Bucket) OpenContext(ctx context.Context) (err error) {
+// OpenContext opens a new bucket connection, the Open timeout of the bucket
+// is applied if ctx has no deadline. For the file system buckets, the stale
+// temporary files of interrupted writes are removed (see StaleTempAge).
+func (b *Bucket) OpenContext(ctx context.Context) error {
+	b.openMu.Lock()
+	defer b.openMu.Unlock()
+	return b.openLocked(ctx)
+}
+
+// open opens the bucket if it is not open yet, it is safe to call concurrently
+func (b *Bucket) open(ctx context.Context) error {
+	b.openMu.Lock()
+	defer b.openMu.Unlock()
+	if b.bucket != nil {
+		return nil
+	}
+	return b.openLocked(ctx)
+}
+
+// openLocked opens a new bucket connection, openMu must be held
+func (b *Bucket) openLocked(ctx context.Context) (err error) {
 	b.parse()
-	b.bucket, err = blob.OpenBucket(ctx, b.url)
-	return err
+	b.bucket, err = b.openBucket(ctx)
+	if err != nil {
+		b.logger.Log(ctx, LevelError, "bucket: open failed", "bucket", b.name, "provider", b.provider.String(), "error", err)
+		return err
+	}
+	b.removeStaleTemp(ctx)
+	return nil
 }
 
 // Open opens a new bucket connection
@@ -154,33 +202,94 @@ func (b *Bucket) Open() error {
 
 // Close opens a new bucket connection
 func (b *Bucket) Close() error {
+	b.openMu.Lock()
+	defer b.openMu.Unlock()
 	if b.bucket != nil {
 		return b.bucket.Close()
 	}
 	return nil
 }
 
+// WriteOptions are the optional attributes of the file being written
+type WriteOptions struct {
+	// ContentType of the file, it is detected from the content if empty
+	ContentType string
+	// CacheControl, ContentDisposition, ContentEncoding and ContentLanguage
+	// are the corresponding http headers to be used while serving the file
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+	// Metadata are the key/value pairs associated with the file
+	Metadata map[string]string
+	// ContentMD5 is the MD5 of the content, the write fails if the content
+	// does not match it. It is computed by Upload if the content is an
+	// io.ReadSeeker, unless DisableChecksums is set.
+	ContentMD5 []byte
+	// DisableChecksums disables computing the digests of the content, which
+	// reads the content twice
+	DisableChecksums bool
+
+	// IfNotExists writes the file only if it does not exist yet, and IfMatch
+	// only if its ETag (as returned by Attributes) is the provided one, the
+	// write fails with a *PreconditionError otherwise. They are enforced by
+	// the provider for GCS, for the others the writes with preconditions are
+	// serialised within the process (and the host, for the file system
+	// buckets) so the check and the write are atomic only among such writes.
+	IfNotExists bool
+	IfMatch     string
+
+	// keyID overrides the key of the encrypted bucket for the write, see Rekey
+	keyID string
+}
+
+// writerOptions converts the options for the blob writer
+func (o *WriteOptions) writerOptions() *blob.WriterOptions {
+	if o == nil {
+		return nil
+	}
+	return &blob.WriterOptions{
+		ContentType:        o.ContentType,
+		CacheControl:       o.CacheControl,
+		ContentDisposition: o.ContentDisposition,
+		ContentEncoding:    o.ContentEncoding,
+		ContentLanguage:    o.ContentLanguage,
+		Metadata:           o.Metadata,
+		ContentMD5:         o.ContentMD5,
+	}
+}
+
 // WriteAll will upload the content of data, under the provided path/name in name
 // and returns the corresponding access url or error if any
 func (b *Bucket) WriteAll(ctx context.Context, name string, data []byte) (string, error) {
+	return b.Upload(ctx, name, bytes.NewReader(data), nil)
+}
+
+// Upload will stream the content of r, under the provided path/name in name
+// with the provided options (can be nil), and returns the corresponding
+// access url or error if any. The write is aborted if reading r fails.
+// If r is an io.ReadSeeker (as for WriteAll), the digests of the content are
+// computed before writing, the write fails if the content written does not
+// match them, and they are stored in the metadata (see MetadataSHA256).
+// Otherwise they are computed while writing, and are not stored, but the
+// write is aborted with ErrChecksumMismatch if the opts metadata has digests
+// the content does not match, e.g. the ones of a file being copied.
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader, opts *WriteOptions) (string, error) {
 	if name == "" {
 		return "", errors.New("bucket: name of file-content is required")
 	}
-	if b.bucket == nil {
-		if err := b.OpenContext(ctx); err != nil {
-			return "", err
-		}
+	op := &Operation{Kind: OpWrite, Name: name, Size: -1}
+	if l, ok := r.(interface{ Len() int }); ok {
+		op.Size = int64(l.Len())
 	}
-
-	w, err := b.bucket.NewWriter(ctx, name, nil)
-	if err != nil {
+	var err error
+	if op.Body, op.Options, err = withChecksums(r, opts); err != nil {
 		return "", err
 	}
-	if _, err := w.Write(data); err != nil {
-		_ = w.Close()
+	if err := b.do(ctx, op); err != nil {
 		return "", err
 	}
-	return b.GetUrl(name), w.Close()
+	return b.GetUrl(name), nil
 }
 
 // GetUrl returns the access url path for the provided name in the corresponding provider
@@ -224,29 +333,56 @@ func (b *Bucket) GetName(link string) string {
 // Reader will return the io.ReadCloser against the file name provided, remember to close reader
 // * name should be file name, not the http-link to get name from link use GetName method
 func (b *Bucket) Reader(ctx context.Context, name string) (io.ReadCloser, error) {
+	if b.verifyOnRead {
+		return b.verifiedReader(ctx, name)
+	}
+	return b.RangeReader(ctx, name, 0, -1)
+}
+
+// RangeReader will return the io.ReadCloser reading length bytes from the offset of the file name
+// provided, a negative length reads till the end of the file, remember to close reader
+// * name should be file name, not the http-link to get name from link use GetName method
+func (b *Bucket) RangeReader(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
 	if name == "" {
 		return nil, errors.New("bucket: name of file-content is required")
 	}
-	if b.bucket == nil {
-		if err := b.OpenContext(ctx); err != nil {
-			return nil, err
-		}
+	op := &Operation{Kind: OpRead, Name: name, Offset: offset, Length: length}
+	if err := b.do(ctx, op); err != nil {
+		return nil, err
+	}
+	return op.Reader, nil
+}
+
+// PinnedRangeReader returns the reader of length bytes (all of the rest if
+// negative) from offset, of the version of the file name having the attrs,
+// as returned by Attributes. It fails with ErrObjectChanged if the file has
+// been overwritten or deleted since.
+func (b *Bucket) PinnedRangeReader(ctx context.Context, name string, attrs *blob.Attributes, offset, length int64) (io.ReadCloser, error) {
+	if attrs == nil {
+		return nil, errors.New("bucket: attributes of the file are required")
 	}
-	return b.bucket.NewReader(ctx, name, nil)
+	op := &Operation{Kind: OpRead, Name: name, Offset: offset, Length: length, Attributes: attrs}
+	if err := b.do(ctx, op); err != nil {
+		return nil, err
+	}
+	return op.Reader, nil
 }
 
 // ReadAll will read all content of file name in bucket
 // * name should be file name, not the http-link to get name from link use GetName method
 func (b *Bucket) ReadAll(ctx context.Context, name string) ([]byte, error) {
-	if name == "" {
-		return nil, errors.New("bucket: name of file-content is required")
+	r, err := b.Reader(ctx, name)
+	if err != nil {
+		return nil, err
 	}
-	if b.bucket == nil {
-		if err := b.OpenContext(ctx); err != nil {
-			return nil, err
-		}
+	data, err := ioutil.ReadAll(r)
+	if cerr := r.Close(); err == nil {
+		err = cerr
 	}
-	return b.bucket.ReadAll(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 // Delete will delete the file name provided from corresponding provider
@@ -255,10 +391,57 @@ func (b *Bucket) Delete(ctx context.Context, name string) error {
 	if name == "" {
 		return errors.New("bucket: name of file-content is required")
 	}
-	if b.bucket == nil {
-		if err := b.OpenContext(ctx); err != nil {
-			return err
-		}
+	return b.do(ctx, &Operation{Kind: OpDelete, Name: name})
+}
+
+// Attributes will return the attributes (size, content type, md5, etc.) of the file name provided
+// * name should be file name, not the http-link to get name from link use GetName method
+func (b *Bucket) Attributes(ctx context.Context, name string) (*blob.Attributes, error) {
+	if name == "" {
+		return nil, errors.New("bucket: name of file-content is required")
+	}
+	op := &Operation{Kind: OpStat, Name: name}
+	if err := b.do(ctx, op); err != nil {
+		return nil, err
+	}
+	return op.Attributes, nil
+}
+
+// List will return all the files in the bucket whose name starts with the provided prefix
+func (b *Bucket) List(ctx context.Context, prefix string) ([]*blob.ListObject, error) {
+	op := &Operation{Kind: OpList, Name: prefix}
+	if err := b.do(ctx, op); err != nil {
+		return nil, err
+	}
+	return op.callerObjects(prefix), nil
+}
+
+// ListPage lists a page of at most size files under the prefix, the one of
+// the page token (the first page if nil), and returns the token of the next
+// page, nil for the last one. The files are listed in the order of their
+// names, and with a delimiter (e.g. "/") the files sharing the part of their
+// name from the prefix to it are grouped as a single object having IsDir set,
+// e.g. the sub-directories, so that only a page is ever read from the provider.
+func (b *Bucket) ListPage(ctx context.Context, prefix, delimiter string, token []byte, size int) ([]*blob.ListObject, []byte, error) {
+	if size <= 0 {
+		return nil, nil, errors.New("bucket: page size must be positive")
+	}
+	op := &Operation{Kind: OpList, Name: prefix, Delimiter: delimiter, PageSize: size, PageToken: token}
+	if err := b.do(ctx, op); err != nil {
+		return nil, nil, err
+	}
+	return op.callerObjects(prefix), op.NextPageToken, nil
+}
+
+// Copy will copy the content and attributes of file src into the file dst
+// and returns the corresponding access url of dst or error if any
+// * names should be file names, not the http-links to get name from link use GetName method
+func (b *Bucket) Copy(ctx context.Context, dst, src string) (string, error) {
+	if dst == "" || src == "" {
+		return "", errors.New("bucket: name of file-content is required")
+	}
+	if err := b.do(ctx, &Operation{Kind: OpCopy, Name: dst, Source: src}); err != nil {
+		return "", err
 	}
-	return b.bucket.Delete(ctx, name)
+	return b.GetUrl(dst), nil
 }
@@ -0,0 +1,108 @@
+package upload
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path"
+
+	"gocloud.dev/gcerrors"
+)
+
+// DefaultFanOut is the number of directory levels of the content addressed
+// files, if ContentAddressedOptions.FanOut is zero
+const DefaultFanOut = 2
+
+// ContentAddressedOptions configures the files written by WriteContentAddressed
+type ContentAddressedOptions struct {
+	// WriteOptions are the attributes of the file, used if it is written
+	WriteOptions
+	// Prefix of the file names, e.g. "avatars/"
+	Prefix string
+	// FanOut is the number of directory levels, named by two characters of
+	// the hash each, e.g. 2 for "ab/cd/abcd...", it is DefaultFanOut if zero
+	// and there are no levels if negative
+	FanOut int
+}
+
+// name returns the name of the file with the hex encoded SHA-256 sum
+func (o *ContentAddressedOptions) name(sum string) string {
+	fanOut, prefix := DefaultFanOut, ""
+	if o != nil {
+		prefix = o.Prefix
+		if o.FanOut != 0 {
+			fanOut = o.FanOut
+		}
+	}
+	parts := []string{prefix}
+	for i := 0; i < fanOut && 2*i+2 <= len(sum); i++ {
+		parts = append(parts, sum[2*i:2*i+2])
+	}
+	return path.Join(append(parts, sum)...)
+}
+
+// WriteContentAddressed writes the content of r into the file named by its
+// SHA-256 (see ContentAddressedOptions), unless the file already exists, and
+// returns the corresponding access url or error if any. So the same content
+// is stored only once.
+//
+// If r is an io.ReadSeeker (e.g. a bytes.Reader) it is hashed before writing,
+// otherwise the content is first written into the staging prefix of the bucket
+// (see SetStagingPrefix), and then copied into place.
+func (b *Bucket) WriteContentAddressed(ctx context.Context, r io.Reader, opts *ContentAddressedOptions) (string, error) {
+	wopts := &WriteOptions{}
+	if opts != nil {
+		*wopts = opts.WriteOptions
+	}
+	wopts.DisableChecksums = false
+	if _, ok := r.(io.ReadSeeker); ok {
+		_, o, err := withChecksums(r, wopts)
+		if err != nil {
+			return "", err
+		}
+		if sum := o.Metadata[MetadataSHA256]; sum != "" {
+			name := opts.name(sum)
+			if exists, err := b.exists(ctx, name); err != nil {
+				return "", err
+			} else if exists {
+				return b.GetUrl(name), nil
+			}
+			// the digests are computed already
+			o.DisableChecksums = true
+			return b.Upload(ctx, name, r, o)
+		}
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	staged := b.stagingName(hex.EncodeToString(id), "content")
+	sha := sha256.New()
+	if _, err := b.Upload(ctx, staged, io.TeeReader(r, sha), wopts); err != nil {
+		return "", err
+	}
+	defer func() { _ = b.Delete(context.Background(), staged) }()
+
+	name := opts.name(hex.EncodeToString(sha.Sum(nil)))
+	if exists, err := b.exists(ctx, name); err != nil {
+		return "", err
+	} else if exists {
+		return b.GetUrl(name), nil
+	}
+	return b.Copy(ctx, name, staged)
+}
+
+// exists reports whether the file name exists
+func (b *Bucket) exists(ctx context.Context, name string) (bool, error) {
+	_, err := b.Attributes(ctx, name)
+	switch gcerrors.Code(err) {
+	case gcerrors.OK:
+		return true, nil
+	case gcerrors.NotFound:
+		return false, nil
+	}
+	return false, err
+}
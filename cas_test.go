@@ -0,0 +1,65 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriteContentAddressed(t *testing.T) {
+	ctx := context.Background()
+	bucket := NewBucket("mem://")
+	writes := 0
+	bucket.Use(func(next Op) Op {
+		return func(ctx context.Context, op *Operation) error {
+			if op.Kind == OpWrite || op.Kind == OpCopy {
+				writes++
+			}
+			return next(ctx, op)
+		}
+	})
+	sum := sha256.Sum256([]byte("avatar"))
+	hash := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name   string
+		body   func() io.Reader
+		opts   *ContentAddressedOptions
+		want   string
+		writes int
+	}{
+		{name: "default", body: func() io.Reader { return strings.NewReader("avatar") },
+			want: hash[:2] + "/" + hash[2:4] + "/" + hash, writes: 1},
+		{name: "duplicate", body: func() io.Reader { return strings.NewReader("avatar") },
+			want: hash[:2] + "/" + hash[2:4] + "/" + hash, writes: 0},
+		{name: "stream duplicate", body: func() io.Reader { return onlyReader{strings.NewReader("avatar")} },
+			want: hash[:2] + "/" + hash[2:4] + "/" + hash, writes: 1},
+		{name: "prefix", body: func() io.Reader { return strings.NewReader("avatar") },
+			opts: &ContentAddressedOptions{Prefix: "avatars/", FanOut: 1}, want: "avatars/" + hash[:2] + "/" + hash, writes: 1},
+		{name: "stream", body: func() io.Reader { return onlyReader{strings.NewReader("avatar")} },
+			opts: &ContentAddressedOptions{Prefix: "flat", FanOut: -1, WriteOptions: WriteOptions{ContentType: "image/png"}},
+			want: "flat/" + hash, writes: 2},
+	}
+	for _, tt := range tests {
+		writes = 0
+		link, err := bucket.WriteContentAddressed(ctx, tt.body(), tt.opts)
+		if err != nil || link != bucket.GetUrl(tt.want) {
+			t.Errorf("WriteContentAddressed(%v), got: %v, %v want: %v \n", tt.name, link, err, tt.want)
+		}
+		if writes != tt.writes {
+			t.Errorf("WriteContentAddressed(%v), writes got: %v want: %v \n", tt.name, writes, tt.writes)
+		}
+		if con, err := bucket.ReadAll(ctx, tt.want); err != nil || string(con) != "avatar" {
+			t.Errorf("ReadAll(%v), got: %s, %v want: avatar \n", tt.want, con, err)
+		}
+	}
+	if attrs, err := bucket.Attributes(ctx, "flat/"+hash); err != nil || attrs.ContentType != "image/png" {
+		t.Errorf("Attributes(flat/%v), got: %v, %v want: image/png \n", hash, attrs, err)
+	}
+	if objs, err := bucket.List(ctx, DefaultStagingPrefix); err != nil || len(objs) != 0 {
+		t.Errorf("List(%v), got: %v, %v want: none \n", DefaultStagingPrefix, len(objs), err)
+	}
+}
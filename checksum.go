@@ -0,0 +1,152 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// ErrChecksumMismatch is returned when the content read does not match the
+// checksum of the file
+var ErrChecksumMismatch = errors.New("bucket: checksum mismatch")
+
+// Metadata keys of the digests of the content, stored by Upload
+const (
+	// MetadataSHA256 is the hex encoded SHA-256 digest of the content
+	MetadataSHA256 = "upload-sha256"
+	// MetadataCRC32C is the hex encoded CRC-32C (Castagnoli) checksum of the content
+	MetadataCRC32C = "upload-crc32c"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// withChecksums returns the body and options of the write of the content of
+// r, with its digests.
+//
+// If r is an io.ReadSeeker it is read and then rewound: the MD5 is set as the
+// ContentMD5, verified by the providers while writing, and the SHA-256 and
+// CRC-32C are stored in the metadata, the write fails with ErrChecksumMismatch
+// if the options already have different ones. Otherwise, the digests are
+// computed while writing, the providers requiring the metadata beforehand, so
+// they are not stored, but the write is aborted with ErrChecksumMismatch
+// before being committed if they do not match the ones of the options. The
+// body and options are returned unchanged if the checksums are disabled.
+func withChecksums(r io.Reader, opts *WriteOptions) (io.Reader, *WriteOptions, error) {
+	if opts != nil && opts.DisableChecksums {
+		return r, opts, nil
+	}
+	var want map[string]string
+	if opts != nil {
+		want = opts.Metadata
+	}
+	rs, ok := r.(io.ReadSeeker)
+	start, err := int64(0), error(nil)
+	if ok {
+		start, err = rs.Seek(0, io.SeekCurrent)
+	}
+	if !ok || err != nil {
+		return verifiedBody(r, want), opts, nil
+	}
+	md5sum, sha, crc := md5.New(), sha256.New(), crc32.New(crc32c)
+	if _, err := io.Copy(io.MultiWriter(md5sum, sha, crc), rs); err != nil {
+		return nil, nil, err
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	shaSum, crcSum := hex.EncodeToString(sha.Sum(nil)), hex.EncodeToString(crc.Sum(nil))
+	if v := want[MetadataSHA256]; v != "" && v != shaSum {
+		return nil, nil, ErrChecksumMismatch
+	}
+	if v := want[MetadataCRC32C]; v != "" && v != crcSum {
+		return nil, nil, ErrChecksumMismatch
+	}
+
+	o := &WriteOptions{}
+	if opts != nil {
+		*o = *opts
+	}
+	if len(o.ContentMD5) == 0 {
+		o.ContentMD5 = md5sum.Sum(nil)
+	}
+	o.Metadata = make(map[string]string, len(want)+2)
+	for k, v := range want {
+		o.Metadata[k] = v
+	}
+	o.Metadata[MetadataSHA256] = shaSum
+	o.Metadata[MetadataCRC32C] = crcSum
+	return r, o, nil
+}
+
+// verifiedBody returns the body reading r, which fails with ErrChecksumMismatch
+// at EOF if the digests of the content do not match the ones of the metadata
+func verifiedBody(r io.Reader, metadata map[string]string) io.Reader {
+	if want, err := hex.DecodeString(metadata[MetadataSHA256]); err == nil && len(want) > 0 {
+		r = &verifyingReader{ReadCloser: ioutil.NopCloser(r), hash: sha256.New(), want: want}
+	}
+	if want, err := hex.DecodeString(metadata[MetadataCRC32C]); err == nil && len(want) > 0 {
+		r = &verifyingReader{ReadCloser: ioutil.NopCloser(r), hash: crc32.New(crc32c), want: want}
+	}
+	return r
+}
+
+// SetVerifyOnRead enables verifying the content read by Reader (and ReadAll)
+// against the SHA-256 stored by Upload, or the MD5 reported by the provider,
+// the reader then fails with ErrChecksumMismatch at EOF if they differ.
+// It should be called before the bucket is put to use.
+func (b *Bucket) SetVerifyOnRead(verify bool) {
+	b.verifyOnRead = verify
+}
+
+// verifiedReader returns the reader of the file name, verifying its content
+func (b *Bucket) verifiedReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	attrs, err := b.Attributes(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := b.RangeReader(ctx, name, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	var digest []byte
+	if b.keys != nil && attrs.Metadata[MetadataKeyID] != "" {
+		// the digest of the encrypted files is sealed, see SetEncryption
+		if digest, err = b.sealedDigest(ctx, attrs); err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+	} else if d, err := hex.DecodeString(attrs.Metadata[MetadataSHA256]); err == nil {
+		digest = d
+	}
+	if len(digest) > 0 {
+		return &verifyingReader{ReadCloser: r, hash: sha256.New(), want: digest}, nil
+	}
+	if len(attrs.MD5) > 0 {
+		return &verifyingReader{ReadCloser: r, hash: md5.New(), want: attrs.MD5}, nil
+	}
+	return r, nil
+}
+
+// verifyingReader fails with ErrChecksumMismatch at EOF if the digest of the
+// content read is not the expected one
+type verifyingReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	want []byte
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(v.hash.Sum(nil), v.want) {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
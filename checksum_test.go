@@ -0,0 +1,110 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gocloud.dev/gcerrors"
+)
+
+// onlyReader hides the io.Seeker of its reader
+type onlyReader struct {
+	r io.Reader
+}
+
+func (o onlyReader) Read(p []byte) (int, error) {
+	return o.r.Read(p)
+}
+
+func TestChecksums(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bucket := NewBucket("file://" + dir)
+	sum := sha256.Sum256([]byte("one.in"))
+
+	tests := []struct {
+		name   string
+		body   func() io.Reader
+		opts   *WriteOptions
+		sha256 string
+		crc32c string
+		code   gcerrors.ErrorCode
+	}{
+		{name: "seeker", body: func() io.Reader { return strings.NewReader("one.in") },
+			opts: &WriteOptions{Metadata: map[string]string{"key": "val"}}, sha256: hex.EncodeToString(sum[:]), crc32c: "52b8d1b3"},
+		{name: "stream", body: func() io.Reader { return onlyReader{strings.NewReader("one.in")} }},
+		{name: "disabled", body: func() io.Reader { return strings.NewReader("one.in") },
+			opts: &WriteOptions{DisableChecksums: true}},
+		{name: "content-md5", body: func() io.Reader { return strings.NewReader("one.in") },
+			opts: &WriteOptions{ContentMD5: []byte("0123456789abcdef")}, code: gcerrors.FailedPrecondition},
+		{name: "stream-sha256", body: func() io.Reader { return onlyReader{strings.NewReader("one.in")} },
+			opts: &WriteOptions{Metadata: map[string]string{MetadataSHA256: hex.EncodeToString(sum[:])}}, sha256: hex.EncodeToString(sum[:])},
+		{name: "stream-crc32c", body: func() io.Reader { return onlyReader{strings.NewReader("one.in")} },
+			opts: &WriteOptions{Metadata: map[string]string{MetadataCRC32C: "52b8d1b3"}}, crc32c: "52b8d1b3"},
+	}
+	for _, tt := range tests {
+		_, err := bucket.Upload(ctx, tt.name, tt.body(), tt.opts)
+		if gcerrors.Code(err) != tt.code {
+			t.Errorf("Upload(%v), got: %v want: %v \n", tt.name, err, tt.code)
+		}
+		if err != nil {
+			continue
+		}
+		attrs, err := bucket.Attributes(ctx, tt.name)
+		if err != nil {
+			t.Fatalf("Attributes(%v), got: %v \n", tt.name, err)
+		}
+		if attrs.Metadata[MetadataSHA256] != tt.sha256 || attrs.Metadata[MetadataCRC32C] != tt.crc32c {
+			t.Errorf("Attributes(%v), got: %v want: %v %v \n", tt.name, attrs.Metadata, tt.sha256, tt.crc32c)
+		}
+	}
+	if tt := tests[0]; tt.opts.Metadata[MetadataSHA256] != "" {
+		t.Errorf("Upload(%v), options modified: %v \n", tt.name, tt.opts.Metadata)
+	}
+
+	// digests not matching the content abort the write
+	want := map[string]string{MetadataSHA256: hex.EncodeToString(sum[:]), MetadataCRC32C: "52b8d1b3"}
+	for _, seekable := range []bool{true, false} {
+		for _, key := range []string{MetadataSHA256, MetadataCRC32C} {
+			var body io.Reader = strings.NewReader("0ne.in")
+			if !seekable {
+				body = onlyReader{body}
+			}
+			if _, err := bucket.Upload(ctx, "corrupt", body, &WriteOptions{Metadata: map[string]string{key: want[key]}}); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("Upload(corrupt, %T, %v), got: %v want: %v \n", body, key, err, ErrChecksumMismatch)
+			}
+			if _, err := bucket.Attributes(ctx, "corrupt"); gcerrors.Code(err) != gcerrors.NotFound {
+				t.Errorf("Attributes(corrupt), got: %v want: not found \n", err)
+			}
+		}
+	}
+
+	// content corrupted in the storage
+	if err := ioutil.WriteFile(filepath.Join(dir, "seeker"), []byte("0ne.in"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "stream"), []byte("0ne.in"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"seeker", "stream"} {
+		if _, err := bucket.ReadAll(ctx, name); err != nil {
+			t.Errorf("ReadAll(%v), got: %v want: nil \n", name, err)
+		}
+	}
+	bucket.SetVerifyOnRead(true)
+	for _, name := range []string{"seeker", "stream"} {
+		if _, err := bucket.ReadAll(ctx, name); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("ReadAll(%v), got: %v want: %v \n", name, err, ErrChecksumMismatch)
+		}
+	}
+	if con, err := bucket.ReadAll(ctx, "disabled"); err != nil || string(con) != "one.in" {
+		t.Errorf("ReadAll(disabled), got: %s, %v want: one.in \n", con, err)
+	}
+}
@@ -0,0 +1,172 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// errComposeUnsupported is returned by OpCompose when the files cannot be
+// concatenated by the provider itself, the caller then streams them instead
+var errComposeUnsupported = errors.New("bucket: compose is not supported")
+
+// maxComposeSources is the maximum number of sources of a single GCS compose
+const maxComposeSources = 32
+
+// minPartSizeS3 is the minimum size of the parts of an S3 multipart upload,
+// except the last one
+const minPartSizeS3 = 5 << 20
+
+// composable reports whether the parts of a resumable upload can be
+// concatenated by the provider itself, see compose
+func (b *Bucket) composable(parts []UploadedPart) bool {
+	if b.keys != nil || len(parts) == 0 {
+		return false
+	}
+	switch b.provider {
+	case GoogleCloud:
+		return true
+	case AmazonWebServices:
+		for _, p := range parts[:len(parts)-1] {
+			if p.Size < minPartSizeS3 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compose concatenates the files sources, in order, into the file name using
+// the API of the provider, without reading their content. It returns
+// errComposeUnsupported if the provider has no such API, or it cannot be used
+// for these files.
+func (b *Bucket) compose(ctx context.Context, name string, sources []string) error {
+	if len(sources) == 0 || !nativeKey(name) {
+		return errComposeUnsupported
+	}
+	for _, src := range sources {
+		if !nativeKey(src) {
+			return errComposeUnsupported
+		}
+	}
+	switch b.provider {
+	case GoogleCloud:
+		return b.composeGCS(ctx, name, sources)
+	case AmazonWebServices:
+		return b.composeS3(ctx, name, sources)
+	}
+	return errComposeUnsupported
+}
+
+// composeGCS composes the sources into the file name, in batches of at most
+// maxComposeSources files, each one composed after the result of the previous
+// one into an intermediate file, so that the file name is only written once
+func (b *Bucket) composeGCS(ctx context.Context, name string, sources []string) error {
+	var client *storage.Client
+	if !b.bucket.As(&client) {
+		return errComposeUnsupported
+	}
+	// the content type is the one detected for the start of the first source
+	attrs, err := b.bucket.Attributes(ctx, sources[0])
+	if err != nil {
+		return err
+	}
+	bkt := client.Bucket(b.name)
+	temp := bkt.Object(sources[0] + ".compose")
+	if len(sources) > maxComposeSources {
+		defer func() { _ = temp.Delete(ctx) }()
+	}
+	srcs := make([]*storage.ObjectHandle, len(sources))
+	for i, src := range sources {
+		srcs[i] = bkt.Object(src)
+	}
+	for {
+		n, dst := len(srcs), bkt.Object(name)
+		if n > maxComposeSources {
+			n, dst = maxComposeSources, temp
+		}
+		c := dst.ComposerFrom(srcs[:n]...)
+		c.ContentType = attrs.ContentType
+		if _, err := c.Run(ctx); err != nil {
+			return err
+		}
+		if n == len(srcs) {
+			return nil
+		}
+		srcs = append([]*storage.ObjectHandle{temp}, srcs[n:]...)
+	}
+}
+
+// composeS3 copies the sources as the parts of a multipart upload of the file
+// name, all of them but the last one must be of at least minPartSizeS3 bytes
+func (b *Bucket) composeS3(ctx context.Context, name string, sources []string) error {
+	var client *s3.S3
+	if !b.bucket.As(&client) {
+		return errComposeUnsupported
+	}
+	attrs, err := b.bucket.Attributes(ctx, sources[0])
+	if err != nil {
+		return err
+	}
+	mu, err := client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(b.name),
+		Key:         aws.String(name),
+		ContentType: aws.String(attrs.ContentType),
+	})
+	if err != nil {
+		return err
+	}
+	completed := &s3.CompletedMultipartUpload{}
+	for i, src := range sources {
+		part, err := client.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:     aws.String(b.name),
+			Key:        aws.String(name),
+			UploadId:   mu.UploadId,
+			PartNumber: aws.Int64(int64(i + 1)),
+			CopySource: aws.String(b.name + "/" + src),
+		})
+		if err != nil {
+			abortS3(ctx, client, mu)
+			return err
+		}
+		completed.Parts = append(completed.Parts, &s3.CompletedPart{
+			ETag:       part.CopyPartResult.ETag,
+			PartNumber: aws.Int64(int64(i + 1)),
+		})
+	}
+	_, err = client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.name),
+		Key:             aws.String(name),
+		UploadId:        mu.UploadId,
+		MultipartUpload: completed,
+	})
+	if err != nil {
+		abortS3(ctx, client, mu)
+	}
+	return err
+}
+
+// abortS3 aborts the multipart upload mu, so that its parts are not kept
+func abortS3(ctx context.Context, client *s3.S3, mu *s3.CreateMultipartUploadOutput) {
+	_, _ = client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   mu.Bucket,
+		Key:      mu.Key,
+		UploadId: mu.UploadId,
+	})
+}
+
+// nativeKey reports whether the name is used as it is by the providers, i.e.
+// it is not escaped by the blob drivers, and so can be passed to their APIs
+func nativeKey(name string) bool {
+	for _, r := range name {
+		if r < 32 {
+			return false
+		}
+	}
+	return !strings.Contains(name, "../") && !strings.Contains(name, "//")
+}
@@ -0,0 +1,99 @@
+package upload
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+)
+
+// AttachmentDisposition returns the value of the Content-Disposition header,
+// as per RFC 6266, forcing the download of the file as filename. The filename
+// is stripped of any directory and control characters, and is set both as
+// the ASCII fallback and as the UTF-8 encoded filename* parameter.
+func AttachmentDisposition(filename string) string {
+	filename = path.Base(strings.ReplaceAll(filename, `\`, "/"))
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filename)
+	if filename == "" || filename == "." || filename == "/" {
+		return "attachment"
+	}
+	fallback := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || r == '"' || r == '\\' || r == '%' {
+			return '_'
+		}
+		return r
+	}, filename)
+	disposition := `attachment; filename="` + fallback + `"`
+	if fallback != filename {
+		disposition += "; filename*=UTF-8''" + encodeExtValue(filename)
+	}
+	return disposition
+}
+
+// encodeExtValue percent-encodes s except the attr-chars of RFC 5987
+func encodeExtValue(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&15])
+	}
+	return b.String()
+}
+
+// GetDownloadUrl returns the link of the file name which is downloaded, rather
+// than displayed, by the browsers, as filename (the base of name if empty).
+// For the cloud providers it is a signed link, valid for blob.DefaultSignedURLExpiry,
+// which requires the bucket to be opened with signing credentials; for the
+// others it is the link served by the handler, with the download and filename
+// query parameters.
+func (b *Bucket) GetDownloadUrl(name, filename string) (string, error) {
+	if filename == "" {
+		filename = path.Base(name)
+	}
+	disposition := AttachmentDisposition(filename)
+	switch b.provider {
+	case GoogleCloud, AmazonWebServices:
+		if err := b.open(context.Background()); err != nil {
+			return "", err
+		}
+		return b.bucket.SignedURL(context.Background(), name, &blob.SignedURLOptions{
+			Method: http.MethodGet,
+			BeforeSign: func(as func(interface{}) bool) error {
+				var gcsOpts *storage.SignedURLOptions
+				var s3Input *s3.GetObjectInput
+				switch {
+				case as(&gcsOpts):
+					if gcsOpts.QueryParameters == nil {
+						gcsOpts.QueryParameters = url.Values{}
+					}
+					gcsOpts.QueryParameters.Set("response-content-disposition", disposition)
+				case as(&s3Input):
+					s3Input.ResponseContentDisposition = &disposition
+				}
+				return nil
+			},
+		})
+	}
+	q := url.Values{}
+	q.Set("download", "1")
+	q.Set("filename", filename)
+	return b.GetUrl(name) + "?" + q.Encode(), nil
+}
@@ -0,0 +1,41 @@
+package upload
+
+import "testing"
+
+func TestAttachmentDisposition(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{filename: "report.pdf", want: `attachment; filename="report.pdf"`},
+		{filename: "../dir/report.pdf", want: `attachment; filename="report.pdf"`},
+		{filename: `C:\dir\report.pdf`, want: `attachment; filename="report.pdf"`},
+		{filename: "re\"po\nrt.pdf", want: `attachment; filename="re_port.pdf"; filename*=UTF-8''re%22port.pdf`},
+		{filename: "résumé; v1.pdf", want: `attachment; filename="r_sum_; v1.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9%3B%20v1.pdf`},
+		{filename: "", want: "attachment"},
+	}
+	for _, tt := range tests {
+		if got := AttachmentDisposition(tt.filename); got != tt.want {
+			t.Errorf("AttachmentDisposition(%q), got: %v want: %v \n", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestGetDownloadUrl(t *testing.T) {
+	tests := []struct {
+		bucket   string
+		name     string
+		filename string
+		want     string
+	}{
+		{bucket: "pfs://example.com/tmp/files?route=public", name: "dir/report.pdf", want: "http://example.com/public/dir/report.pdf?download=1&filename=report.pdf"},
+		{bucket: "pfs://example.com/tmp/files?secure=true", name: "a.pdf", filename: "b c.pdf", want: "https://example.com/a.pdf?download=1&filename=b+c.pdf"},
+		{bucket: "mem://", name: "a.pdf", want: "a.pdf?download=1&filename=a.pdf"},
+	}
+	for _, tt := range tests {
+		got, err := NewBucket(tt.bucket).GetDownloadUrl(tt.name, tt.filename)
+		if err != nil || got != tt.want {
+			t.Errorf("GetDownloadUrl(%v, %v), got: %v (%v) want: %v \n", tt.name, tt.filename, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// DefaultPartSize is the size of the ranges read by Download, if
+	// DownloadOptions.PartSize is zero
+	DefaultPartSize = 8 << 20
+	// DefaultConcurrency is the number of ranges read concurrently by
+	// Download, if DownloadOptions.Concurrency is zero
+	DefaultConcurrency = 4
+)
+
+// ErrObjectChanged is returned when the file is changed while being read in
+// several parts, e.g. by Download
+var ErrObjectChanged = errors.New("bucket: file changed while being read")
+
+// DownloadOptions configures the parallel download of a file
+type DownloadOptions struct {
+	// PartSize is the size in bytes of each range read
+	PartSize int64
+	// Concurrency is the maximum number of ranges read concurrently
+	Concurrency int
+}
+
+// Download reads the file name into w, using concurrent range reads of the
+// provided options (can be nil), each written into place. Every range is read
+// from the version of the file found when the download starts, the download
+// fails with ErrObjectChanged if the file is changed meanwhile. If w also
+// implements io.ReaderAt (e.g. *os.File) the content written is verified
+// against the MD5 of the file, when the provider reports it, returning
+// ErrChecksumMismatch if they differ.
+func (b *Bucket) Download(ctx context.Context, name string, w io.WriterAt, opts *DownloadOptions) error {
+	partSize, concurrency := int64(DefaultPartSize), DefaultConcurrency
+	if opts != nil && opts.PartSize > 0 {
+		partSize = opts.PartSize
+	}
+	if opts != nil && opts.Concurrency > 0 {
+		concurrency = opts.Concurrency
+	}
+	attrs, err := b.Attributes(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	offsets := make(chan int64)
+	for i := 0; i < concurrency; i++ {
+		g.Go(func() error {
+			for off := range offsets {
+				length := partSize
+				if off+length > attrs.Size {
+					length = attrs.Size - off
+				}
+				if err := b.downloadPart(gctx, name, attrs, w, off, length); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	g.Go(func() error {
+		defer close(offsets)
+		for off := int64(0); off < attrs.Size; off += partSize {
+			select {
+			case offsets <- off:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	r, ok := w.(io.ReaderAt)
+	if !ok || len(attrs.MD5) == 0 {
+		return nil
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, attrs.Size)); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), attrs.MD5) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// downloadPart reads length bytes of the version of the file name having
+// the attributes, from off, into w at off
+func (b *Bucket) downloadPart(ctx context.Context, name string, attrs *blob.Attributes, w io.WriterAt, off, length int64) error {
+	r, err := b.PinnedRangeReader(ctx, name, attrs, off, length)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	n, err := io.Copy(&offsetWriter{w: w, off: off}, r)
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// DownloadToFile downloads the file name into the local file at path, using
+// Download with the provided options (can be nil). The content is written
+// into a temporary file, renamed to path once downloaded and verified, so
+// that path is never left partially written.
+func (b *Bucket) DownloadToFile(ctx context.Context, name, path string, opts *DownloadOptions) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	err = b.Download(ctx, name, f, opts)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// offsetWriter writes into w sequentially, starting from off
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
+
+// pinnedReaderOptions returns the options of the blob reader pinning the read
+// to the version of the file having the attributes, natively for GCS (using
+// its generation) and S3 (using its ETag), the other reads are checked once
+// opened, see exec
+func pinnedReaderOptions(attrs *blob.Attributes) *blob.ReaderOptions {
+	if attrs == nil {
+		return nil
+	}
+	var oa storage.ObjectAttrs
+	generation := int64(-1)
+	if attrs.As(&oa) {
+		generation = oa.Generation
+	}
+	return &blob.ReaderOptions{BeforeRead: func(as func(interface{}) bool) error {
+		var obj **storage.ObjectHandle
+		if generation >= 0 && as(&obj) {
+			*obj = (*obj).Generation(generation)
+		}
+		var in *s3.GetObjectInput
+		if attrs.ETag != "" && as(&in) {
+			in.IfMatch = aws.String(attrs.ETag)
+		}
+		return nil
+	}}
+}
+
+// isChanged reports whether the error of a pinned read is due to the file
+// being changed or deleted
+func isChanged(err error) bool {
+	switch gcerrors.Code(err) {
+	case gcerrors.NotFound, gcerrors.FailedPrecondition:
+		return true
+	}
+	return false
+}
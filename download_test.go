@@ -0,0 +1,142 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// memWriterAt is an in-memory io.WriterAt
+type memWriterAt struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (m *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if end := int(off) + len(p); end > len(m.buf) {
+		m.buf = append(m.buf, make([]byte, end-len(m.buf))...)
+	}
+	return copy(m.buf[off:], p), nil
+}
+
+func TestDownload(t *testing.T) {
+	ctx := context.Background()
+	bucket := NewBucket("mem://")
+	content := strings.Repeat("0123456789", 1000)
+	if _, err := bucket.WriteAll(ctx, "big.txt", []byte(content)); err != nil {
+		t.Fatalf("WriteAll(big.txt), got: %v \n", err)
+	}
+	if _, err := bucket.WriteAll(ctx, "empty.txt", nil); err != nil {
+		t.Fatalf("WriteAll(empty.txt), got: %v \n", err)
+	}
+	var reads int32
+	bucket.Use(func(next Op) Op {
+		return func(ctx context.Context, op *Operation) error {
+			if op.Kind == OpRead {
+				atomic.AddInt32(&reads, 1)
+			}
+			return next(ctx, op)
+		}
+	})
+
+	tests := []struct {
+		name  string
+		opts  *DownloadOptions
+		want  string
+		reads int32
+	}{
+		{name: "big.txt", want: content, reads: 1},
+		{name: "big.txt", opts: &DownloadOptions{PartSize: 1000, Concurrency: 3}, want: content, reads: 10},
+		{name: "big.txt", opts: &DownloadOptions{PartSize: 3000, Concurrency: 8}, want: content, reads: 4},
+		{name: "empty.txt", opts: &DownloadOptions{PartSize: 10}, want: "", reads: 0},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&reads, 0)
+		w := &memWriterAt{}
+		if err := bucket.Download(ctx, tt.name, w, tt.opts); err != nil || string(w.buf) != tt.want {
+			t.Errorf("Download(%v, %+v), got: %v (%v bytes) want: %v bytes \n", tt.name, tt.opts, err, len(w.buf), len(tt.want))
+		}
+		if got := atomic.LoadInt32(&reads); got != tt.reads {
+			t.Errorf("Download(%v, %+v), reads got: %v want: %v \n", tt.name, tt.opts, got, tt.reads)
+		}
+	}
+	if err := bucket.Download(ctx, "none.txt", &memWriterAt{}, nil); err == nil {
+		t.Errorf("Download(none.txt), got: nil want: not found \n")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "big.txt")
+	if err := bucket.DownloadToFile(ctx, "big.txt", path, &DownloadOptions{PartSize: 4096}); err != nil {
+		t.Fatalf("DownloadToFile(big.txt), got: %v \n", err)
+	}
+	if got, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(got, []byte(content)) {
+		t.Errorf("ReadFile(%v), got: %v (%v bytes) \n", path, err, len(got))
+	}
+
+	// content changed while downloading fails the checksum
+	bucket.Use(func(next Op) Op {
+		return func(ctx context.Context, op *Operation) error {
+			err := next(ctx, op)
+			if op.Kind == OpStat && op.Attributes != nil {
+				op.Attributes.MD5 = []byte("stale")
+			}
+			return err
+		}
+	})
+	if err := bucket.DownloadToFile(ctx, "big.txt", filepath.Join(dir, "stale.txt"), nil); err != ErrChecksumMismatch {
+		t.Errorf("DownloadToFile(big.txt), got: %v want: %v \n", err, ErrChecksumMismatch)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("ReadDir(%v), got: %v files want: 1 \n", dir, len(files))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "stale.txt")); !os.IsNotExist(err) {
+		t.Errorf("Stat(stale.txt), got: %v want: not exist \n", err)
+	}
+}
+
+func TestDownloadChanged(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keys := NewKeyring()
+	if err := keys.Add("k1", make([]byte, 32)); err != nil {
+		t.Fatalf("Add(k1), got: %v \n", err)
+	}
+	for _, encrypted := range []bool{false, true} {
+		bucket, other := NewBucket("file://"+dir), NewBucket("file://"+dir)
+		if encrypted {
+			bucket.SetEncryption(keys, "k1")
+			other.SetEncryption(keys, "k1")
+		}
+		content := strings.Repeat("0123456789", 1000)
+		if _, err := bucket.WriteAll(ctx, "big.txt", []byte(content)); err != nil {
+			t.Fatalf("WriteAll(big.txt), got: %v \n", err)
+		}
+		if err := bucket.Download(ctx, "big.txt", &memWriterAt{}, &DownloadOptions{PartSize: 1000, Concurrency: 1}); err != nil {
+			t.Errorf("Download(big.txt) (encrypted: %v), got: %v \n", encrypted, err)
+		}
+
+		// the file is overwritten, with the same size, once the first part is read
+		var reads int32
+		bucket.Use(func(next Op) Op {
+			return func(ctx context.Context, op *Operation) error {
+				if op.Kind == OpRead && atomic.AddInt32(&reads, 1) == 2 {
+					if _, err := other.WriteAll(ctx, "big.txt", []byte(strings.Repeat("9876543210", 1000))); err != nil {
+						return err
+					}
+				}
+				return next(ctx, op)
+			}
+		})
+		if err := bucket.Download(ctx, "big.txt", &memWriterAt{}, &DownloadOptions{PartSize: 1000, Concurrency: 1}); err != ErrObjectChanged {
+			t.Errorf("Download(big.txt) (encrypted: %v), got: %v want: %v \n", encrypted, err, ErrObjectChanged)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package upload
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"gocloud.dev/blob"
+)
+
+// ErrDecryption is returned when the content of an encrypted file cannot be
+// authenticated, i.e. it has been tampered with or truncated
+var ErrDecryption = errors.New("bucket: decryption failed")
+
+// Metadata keys of the encrypted files, see SetEncryption
+const (
+	// MetadataKeyID is the id of the key wrapping the data key of the file
+	MetadataKeyID = "upload-key-id"
+	// MetadataDataKey is the base64 encoded wrapped data key of the file
+	MetadataDataKey = "upload-data-key"
+	// MetadataSealedSHA256 is the base64 encoded SHA-256 digest of the
+	// plaintext, encrypted using the data key, which replaces MetadataSHA256
+	MetadataSealedSHA256 = "upload-sealed-sha256"
+)
+
+const (
+	// segmentSize is the size of the plaintext of each encrypted segment
+	segmentSize = 64 << 10
+	// dataKeySize is the size of the data keys, for AES-256
+	dataKeySize = 32
+	// aeadOverhead is the size of the authentication tag of each segment
+	aeadOverhead = 16
+)
+
+// KeyProvider wraps and unwraps the data keys of the encrypted files, e.g. using
+// a KMS or a local Keyring
+type KeyProvider interface {
+	// WrapKey encrypts the data key using the key keyID
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts the data key wrapped by WrapKey using the key keyID
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Keyring is a KeyProvider holding the keys in memory, the data keys are
+// wrapped using AES-GCM
+type Keyring struct {
+	mu   sync.RWMutex
+	keys map[string]cipher.AEAD
+}
+
+// NewKeyring returns an empty Keyring, see Add
+func NewKeyring() *Keyring {
+	return &Keyring{keys: map[string]cipher.AEAD{}}
+}
+
+// Add adds the AES key (of 16, 24 or 32 bytes) under the id keyID, replacing
+// any existing one
+func (k *Keyring) Add(keyID string, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.keys[keyID] = aead
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *Keyring) key(keyID string) (cipher.AEAD, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("bucket: unknown key %q", keyID)
+	}
+	return aead, nil
+}
+
+// WrapKey implements KeyProvider
+func (k *Keyring) WrapKey(_ context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	aead, err := k.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+// UnwrapKey implements KeyProvider
+func (k *Keyring) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, err := k.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrDecryption
+	}
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return dataKey, nil
+}
+
+// SetEncryption enables the client-side encryption of the bucket: the content
+// of each file written is encrypted using AES-256-GCM with its own random data
+// key, wrapped by keys using the key keyID and stored in the metadata of the
+// file (see MetadataKeyID), and the encrypted files are decrypted when read.
+// The files written before remain readable as they are. Attributes reports the
+// size of the plaintext, but List and the pfsblob handler, which serves the
+// files from the disk, see the encrypted content. The digests of the
+// plaintext are not stored as they are (see MetadataSealedSHA256), but are
+// still verified on read (see SetVerifyOnRead). It should be called before
+// the bucket is put to use.
+func (b *Bucket) SetEncryption(keys KeyProvider, keyID string) {
+	b.keys, b.keyID = keys, keyID
+}
+
+// encrypt is the innermost interceptor of the encrypted buckets, encrypting
+// the content written and decrypting the content read
+func (b *Bucket) encrypt(next Op) Op {
+	return func(ctx context.Context, op *Operation) error {
+		switch op.Kind {
+		case OpWrite:
+			if err := b.encryptWrite(ctx, op); err != nil {
+				return err
+			}
+			return next(ctx, op)
+		case OpRead:
+			return b.decryptRead(ctx, next, op)
+		case OpStat:
+			if err := next(ctx, op); err != nil {
+				return err
+			}
+			if op.Attributes.Metadata[MetadataKeyID] != "" {
+				op.Attributes.Size = plainSize(op.Attributes.Size)
+				// the MD5 is the one of the encrypted content
+				op.Attributes.MD5 = nil
+				op.Size = op.Attributes.Size
+			}
+			return nil
+		case OpCompose:
+			// each file is encrypted using its own data key, so their
+			// concatenation cannot be decrypted
+			return errComposeUnsupported
+		}
+		return next(ctx, op)
+	}
+}
+
+// encryptWrite replaces the body of the write with its encrypted content, and
+// adds the wrapped data key to the metadata
+func (b *Bucket) encryptWrite(ctx context.Context, op *Operation) error {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	keyID := b.keyID
+	if op.Options != nil && op.Options.keyID != "" {
+		keyID = op.Options.keyID
+	}
+	wrapped, err := b.keys.WrapKey(ctx, keyID, dataKey)
+	if err != nil {
+		return err
+	}
+	aead, err := newSegmentCipher(dataKey)
+	if err != nil {
+		return err
+	}
+
+	o := &WriteOptions{}
+	if op.Options != nil {
+		*o = *op.Options
+	}
+	o.ContentMD5 = nil
+	o.Metadata = make(map[string]string, len(o.Metadata)+2)
+	if op.Options != nil {
+		for k, v := range op.Options.Metadata {
+			o.Metadata[k] = v
+		}
+	}
+	o.Metadata[MetadataKeyID] = keyID
+	o.Metadata[MetadataDataKey] = base64.StdEncoding.EncodeToString(wrapped)
+	// the digests of the plaintext would let anyone reading the metadata
+	// confirm a guess of the content: the SHA-256 is sealed using the data
+	// key and the CRC-32C is dropped
+	delete(o.Metadata, MetadataSealedSHA256)
+	if digest, err := hex.DecodeString(o.Metadata[MetadataSHA256]); err == nil && len(digest) > 0 {
+		sealed := aead.Seal(nil, digestNonce(), digest, []byte(MetadataSealedSHA256))
+		o.Metadata[MetadataSealedSHA256] = base64.StdEncoding.EncodeToString(sealed)
+	}
+	delete(o.Metadata, MetadataSHA256)
+	delete(o.Metadata, MetadataCRC32C)
+	body := op.Body
+	if o.ContentType == "" {
+		// detected from the plaintext, the encrypted content being random
+		body, o.ContentType = detectContentType(body)
+	}
+	op.Options = o
+	op.Body = &encryptingReader{r: bufio.NewReader(body), aead: aead}
+	if op.Size >= 0 {
+		op.Size = cipherSize(op.Size)
+	}
+	return nil
+}
+
+// decryptRead reads the range of the plaintext of the file, if it is encrypted
+func (b *Bucket) decryptRead(ctx context.Context, next Op, op *Operation) error {
+	if op.Offset < 0 {
+		// invalid, as reported by the provider
+		return next(ctx, op)
+	}
+	stat := &Operation{Kind: OpStat, Name: op.Name}
+	if err := next(ctx, stat); err != nil {
+		return err
+	}
+	keyID := stat.Attributes.Metadata[MetadataKeyID]
+	if keyID == "" {
+		return next(ctx, op)
+	}
+	if op.Attributes != nil && op.Attributes.ETag != stat.Attributes.ETag {
+		return ErrObjectChanged
+	}
+	aead, err := b.dataCipher(ctx, stat.Attributes)
+	if err != nil {
+		return err
+	}
+
+	size := plainSize(stat.Attributes.Size)
+	offset, length := op.Offset, op.Length
+	if offset > size {
+		offset = size
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	op.Size = size
+	if length == 0 {
+		op.Reader = ioutil.NopCloser(strings.NewReader(""))
+		return nil
+	}
+	first, last := offset/segmentSize, (offset+length-1)/segmentSize
+	start, end := first*(segmentSize+aeadOverhead), (last+1)*(segmentSize+aeadOverhead)
+	if end > stat.Attributes.Size {
+		end = stat.Attributes.Size
+	}
+	raw := &Operation{Kind: OpRead, Name: op.Name, Offset: start, Length: end - start}
+	if op.Attributes != nil {
+		// pinned to the version of the file stated, as the one of op
+		raw.Attributes = stat.Attributes
+	}
+	if err := next(ctx, raw); err != nil {
+		return err
+	}
+	op.Reader = &decryptingReader{
+		ReadCloser: raw.Reader,
+		aead:       aead,
+		seg:        uint64(first),
+		segments:   uint64(segments(size)),
+		skip:       offset - first*segmentSize,
+		left:       length,
+	}
+	return nil
+}
+
+// dataCipher returns the cipher of the content of the encrypted file
+func (b *Bucket) dataCipher(ctx context.Context, attrs *blob.Attributes) (cipher.AEAD, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(attrs.Metadata[MetadataDataKey])
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	dataKey, err := b.keys.UnwrapKey(ctx, attrs.Metadata[MetadataKeyID], wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return newSegmentCipher(dataKey)
+}
+
+// sealedDigest returns the SHA-256 digest of the plaintext of the encrypted
+// file, sealed in its metadata, or nil if there is none
+func (b *Bucket) sealedDigest(ctx context.Context, attrs *blob.Attributes) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(attrs.Metadata[MetadataSealedSHA256])
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	if len(sealed) == 0 {
+		return nil, nil
+	}
+	aead, err := b.dataCipher(ctx, attrs)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := aead.Open(nil, digestNonce(), sealed, []byte(MetadataSealedSHA256))
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return digest, nil
+}
+
+func newSegmentCipher(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentNonce returns the nonce of the segment seg: the data keys being
+// used for a single file, the nonces only need to be unique within the file.
+// The last segment is flagged so that a truncated content fails to decrypt.
+func segmentNonce(seg uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, seg)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// digestNonce returns the nonce sealing the digest of the plaintext, which
+// is flagged so as not to be the nonce of any segment
+func digestNonce() []byte {
+	nonce := make([]byte, 12)
+	nonce[10] = 1
+	return nonce
+}
+
+// segments returns the number of segments of a plaintext of the size, the
+// empty plaintext has a single empty segment
+func segments(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + segmentSize - 1) / segmentSize
+}
+
+// cipherSize returns the size of the encrypted content of the plaintext size
+func cipherSize(size int64) int64 {
+	return size + segments(size)*aeadOverhead
+}
+
+// plainSize returns the size of the plaintext of the encrypted content size
+func plainSize(size int64) int64 {
+	n := (size + segmentSize + aeadOverhead - 1) / (segmentSize + aeadOverhead)
+	if size -= n * aeadOverhead; size < 0 {
+		return 0
+	}
+	return size
+}
+
+// encryptingReader reads the encrypted content of r, segment by segment
+type encryptingReader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	seg   uint64
+	plain []byte
+	buf   []byte
+	done  bool
+}
+
+func (e *encryptingReader) Read(p []byte) (int, error) {
+	for len(e.buf) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		if e.plain == nil {
+			e.plain = make([]byte, segmentSize)
+		}
+		n, err := io.ReadFull(e.r, e.plain)
+		switch err {
+		case nil:
+			// the segment is the last one if nothing follows
+			if _, err := e.r.Peek(1); err == io.EOF {
+				e.done = true
+			} else if err != nil {
+				return 0, err
+			}
+		case io.EOF, io.ErrUnexpectedEOF:
+			e.done = true
+		default:
+			return 0, err
+		}
+		e.buf = e.aead.Seal(e.buf[:0], segmentNonce(e.seg, e.done), e.plain[:n], nil)
+		e.seg++
+	}
+	n := copy(p, e.buf)
+	e.buf = e.buf[n:]
+	return n, nil
+}
+
+// decryptingReader reads the plaintext of the encrypted segments, from the
+// segment seg, skipping the first skip bytes and reading at most left bytes
+type decryptingReader struct {
+	io.ReadCloser
+	aead     cipher.AEAD
+	seg      uint64
+	segments uint64
+	skip     int64
+	left     int64
+	sealed   []byte
+	buf      []byte
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.left == 0 {
+			return 0, io.EOF
+		}
+		if d.sealed == nil {
+			d.sealed = make([]byte, segmentSize+aeadOverhead)
+		}
+		n, err := io.ReadFull(d.ReadCloser, d.sealed)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if d.seg != d.segments-1 {
+				return 0, ErrDecryption
+			}
+		} else if err != nil {
+			return 0, err
+		}
+		d.buf, err = d.aead.Open(d.buf[:0], segmentNonce(d.seg, d.seg == d.segments-1), d.sealed[:n], nil)
+		if err != nil {
+			return 0, ErrDecryption
+		}
+		d.seg++
+		if d.skip > 0 {
+			d.buf = d.buf[d.skip:]
+			d.skip = 0
+		}
+		if int64(len(d.buf)) > d.left {
+			d.buf = d.buf[:d.left]
+		}
+		d.left -= int64(len(d.buf))
+		if len(d.buf) == 0 && d.left > 0 {
+			return 0, ErrDecryption
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
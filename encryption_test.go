@@ -0,0 +1,148 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keys := NewKeyring()
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	if err := keys.Add("k1", key); err != nil {
+		t.Fatalf("Add(k1), got: %v \n", err)
+	}
+	if err := keys.Add("bad", key[:10]); err == nil {
+		t.Errorf("Add(bad), got: nil want: invalid key size \n")
+	}
+	plain := NewBucket("file://" + dir)
+	bucket := NewBucket("file://" + dir)
+	bucket.SetEncryption(keys, "k1")
+	bucket.SetVerifyOnRead(true)
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "small", size: 10},
+		{name: "segment", size: segmentSize},
+		{name: "large", size: 3*segmentSize + 5},
+	}
+	for _, tt := range tests {
+		content := make([]byte, tt.size)
+		_, _ = rand.Read(content)
+		if _, err := bucket.WriteAll(ctx, tt.name, content); err != nil {
+			t.Fatalf("WriteAll(%v), got: %v \n", tt.name, err)
+		}
+		raw, err := ioutil.ReadFile(filepath.Join(dir, tt.name))
+		if err != nil || int64(len(raw)) != cipherSize(int64(tt.size)) || tt.size > 0 && bytes.Contains(raw, content) {
+			t.Errorf("ReadFile(%v), got: %v bytes, %v want: %v encrypted bytes \n", tt.name, len(raw), err, cipherSize(int64(tt.size)))
+		}
+		if attrs, err := plain.Attributes(ctx, tt.name); err != nil || attrs.Metadata[MetadataKeyID] != "k1" {
+			t.Errorf("Attributes(%v), got: %v, %v want key id: k1 \n", tt.name, attrs, err)
+		} else if md := attrs.Metadata; md[MetadataSHA256] != "" || md[MetadataCRC32C] != "" || md[MetadataSealedSHA256] == "" {
+			// the digests of the plaintext are not stored as they are
+			t.Errorf("Attributes(%v), got metadata: %v want: sealed digest only \n", tt.name, md)
+		}
+		if attrs, err := bucket.Attributes(ctx, tt.name); err != nil || attrs.Size != int64(tt.size) {
+			t.Errorf("Attributes(%v), got: %v, %v want size: %v \n", tt.name, attrs, err, tt.size)
+		}
+		if got, err := bucket.ReadAll(ctx, tt.name); err != nil || !bytes.Equal(got, content) {
+			t.Errorf("ReadAll(%v), got: %v bytes, %v want: %v bytes \n", tt.name, len(got), err, tt.size)
+		}
+		for _, r := range [][2]int64{{0, 1}, {5, -1}, {segmentSize - 1, 2}, {segmentSize, segmentSize}, {2*segmentSize + 3, 100}} {
+			off, length := r[0], r[1]
+			want := []byte{}
+			if off < int64(tt.size) {
+				want = content[off:]
+				if length >= 0 && off+length < int64(tt.size) {
+					want = content[off : off+length]
+				}
+			}
+			rc, err := bucket.RangeReader(ctx, tt.name, off, length)
+			if err != nil {
+				t.Errorf("RangeReader(%v, %v, %v), got: %v \n", tt.name, off, length, err)
+				continue
+			}
+			got, err := ioutil.ReadAll(rc)
+			_ = rc.Close()
+			if err != nil || !bytes.Equal(got, want) {
+				t.Errorf("RangeReader(%v, %v, %v), got: %v bytes, %v want: %v bytes \n", tt.name, off, length, len(got), err, len(want))
+			}
+		}
+		w := &memWriterAt{}
+		if err := bucket.Download(ctx, tt.name, w, &DownloadOptions{PartSize: 50000}); err != nil || len(w.buf) != tt.size || !bytes.Equal(w.buf, content[:len(w.buf)]) {
+			t.Errorf("Download(%v), got: %v bytes, %v want: %v bytes \n", tt.name, len(w.buf), err, tt.size)
+		}
+	}
+
+	// the content type is detected from the plaintext
+	if _, err := bucket.WriteAll(ctx, "doc.html", []byte("<html><body>pii</body></html>")); err != nil {
+		t.Fatalf("WriteAll(doc.html), got: %v \n", err)
+	}
+	if attrs, err := bucket.Attributes(ctx, "doc.html"); err != nil || attrs.ContentType != "text/html; charset=utf-8" {
+		t.Errorf("Attributes(doc.html), got: %v, %v want: text/html \n", attrs, err)
+	}
+
+	// the digests given for a streamed content are sealed as well
+	sum := sha256.Sum256([]byte("streamed"))
+	opts := &WriteOptions{Metadata: map[string]string{MetadataSHA256: hex.EncodeToString(sum[:])}}
+	if _, err := bucket.Upload(ctx, "streamed", io.MultiReader(strings.NewReader("streamed")), opts); err != nil {
+		t.Fatalf("Upload(streamed), got: %v \n", err)
+	}
+	if attrs, err := plain.Attributes(ctx, "streamed"); err != nil || attrs.Metadata[MetadataSHA256] != "" || attrs.Metadata[MetadataSealedSHA256] == "" {
+		t.Errorf("Attributes(streamed), got: %v, %v want: sealed digest only \n", attrs, err)
+	}
+	if got, err := bucket.ReadAll(ctx, "streamed"); err != nil || string(got) != "streamed" {
+		t.Errorf("ReadAll(streamed), got: %q, %v want: streamed \n", got, err)
+	}
+
+	// the files written before are readable
+	if _, err := plain.WriteAll(ctx, "plain", []byte("hello")); err != nil {
+		t.Fatalf("WriteAll(plain), got: %v \n", err)
+	}
+	if got, err := bucket.ReadAll(ctx, "plain"); err != nil || string(got) != "hello" {
+		t.Errorf("ReadAll(plain), got: %q, %v want: hello \n", got, err)
+	}
+
+	// tampered content fails to decrypt
+	path := filepath.Join(dir, "small")
+	raw, _ := ioutil.ReadFile(path)
+	raw[0] ^= 1
+	if err := ioutil.WriteFile(path, raw, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bucket.ReadAll(ctx, "small"); err != ErrDecryption {
+		t.Errorf("ReadAll(small), got: %v want: %v \n", err, ErrDecryption)
+	}
+	// truncated content fails to decrypt
+	path = filepath.Join(dir, "large")
+	raw, _ = ioutil.ReadFile(path)
+	if err := ioutil.WriteFile(path, raw[:2*(segmentSize+aeadOverhead)], 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bucket.ReadAll(ctx, "large"); err != ErrDecryption {
+		t.Errorf("ReadAll(large), got: %v want: %v \n", err, ErrDecryption)
+	}
+
+	// unknown keys
+	other := NewBucket("file://" + dir)
+	other.SetEncryption(NewKeyring(), "k1")
+	if _, err := other.ReadAll(ctx, "segment"); err == nil {
+		t.Errorf("ReadAll(segment), got: nil want: unknown key \n")
+	}
+	if _, err := other.WriteAll(ctx, "new", []byte("new")); err == nil {
+		t.Errorf("WriteAll(new), got: nil want: unknown key \n")
+	}
+}
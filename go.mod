@@ -3,6 +3,12 @@ module github.com/Shivam010/upload
 go 1.16
 
 require (
+	cloud.google.com/go/storage v1.15.0
+	github.com/aws/aws-sdk-go v1.38.35
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	gocloud.dev v0.23.0
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+	google.golang.org/api v0.46.0
 )
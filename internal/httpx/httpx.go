@@ -0,0 +1,50 @@
+// Package httpx holds the http helpers shared by the handlers and the
+// instrumentation packages of the module.
+package httpx
+
+import (
+	"net/http"
+
+	"gocloud.dev/gcerrors"
+)
+
+// ResponseRecorder records the status code and number of bytes written
+type ResponseRecorder struct {
+	http.ResponseWriter
+	// Status is the status code of the response, http.StatusOK by default
+	Status int
+	// Written is the number of bytes of the body written
+	Written     int64
+	wroteHeader bool
+}
+
+// NewResponseRecorder returns the ResponseRecorder of w
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *ResponseRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.Status, r.wroteHeader = code, true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *ResponseRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(p)
+	r.Written += int64(n)
+	return n, err
+}
+
+// BucketError responds with the http error corresponding to the bucket error
+func BucketError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch gcerrors.Code(err) {
+	case gcerrors.NotFound:
+		status = http.StatusNotFound
+	case gcerrors.PermissionDenied:
+		status = http.StatusForbidden
+	}
+	http.Error(w, http.StatusText(status), status)
+}
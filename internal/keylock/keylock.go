@@ -0,0 +1,42 @@
+// Package keylock provides the locks of names, e.g. of files or uploads, which
+// exist only while they are held or waited for.
+package keylock
+
+import "sync"
+
+// Locks are the locks of the names, the zero value is ready to use
+type Locks struct {
+	mu    sync.Mutex
+	locks map[string]*lock
+}
+
+type lock struct {
+	sync.Mutex
+	// refs is the number of callers holding or waiting for the lock
+	refs int
+}
+
+// Lock locks the name, and returns its unlock function
+func (l *Locks) Lock(name string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*lock{}
+	}
+	lk, ok := l.locks[name]
+	if !ok {
+		lk = &lock{}
+		l.locks[name] = lk
+	}
+	lk.refs++
+	l.mu.Unlock()
+
+	lk.Lock()
+	return func() {
+		lk.Unlock()
+		l.mu.Lock()
+		if lk.refs--; lk.refs == 0 {
+			delete(l.locks, name)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,53 @@
+// Package parts reads the parts of an upload staged as separate files, one
+// after the other, as a single stream.
+package parts
+
+import "io"
+
+// Reader reads the n parts opened by open, in order, the parts are opened
+// only once the previous ones are read entirely
+type Reader struct {
+	n    int
+	open func(i int) (io.ReadCloser, error)
+	next int
+	r    io.ReadCloser
+}
+
+// NewReader returns the Reader of the n parts opened by open
+func NewReader(n int, open func(i int) (io.ReadCloser, error)) *Reader {
+	return &Reader{n: n, open: open}
+}
+
+func (p *Reader) Read(b []byte) (int, error) {
+	for {
+		if p.r == nil {
+			if p.next >= p.n {
+				return 0, io.EOF
+			}
+			r, err := p.open(p.next)
+			if err != nil {
+				return 0, err
+			}
+			p.r, p.next = r, p.next+1
+		}
+		n, err := p.r.Read(b)
+		if err == io.EOF {
+			_ = p.r.Close()
+			p.r, err = nil, nil
+			if n == 0 {
+				continue
+			}
+		}
+		return n, err
+	}
+}
+
+// Close closes the part being read, if any
+func (p *Reader) Close() error {
+	if p.r == nil {
+		return nil
+	}
+	err := p.r.Close()
+	p.r = nil
+	return err
+}
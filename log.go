@@ -0,0 +1,89 @@
+package upload
+
+import (
+	"context"
+	"time"
+)
+
+// Level is the severity of a log record, its values match the ones of log/slog
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// Logger emits structured log records, args are alternating key-value pairs
+// as in log/slog, e.g. a *slog.Logger can be plugged in using:
+//
+//	upload.LoggerFunc(func(ctx context.Context, level upload.Level, msg string, args ...interface{}) {
+//		logger.Log(ctx, slog.Level(level), msg, args...)
+//	})
+type Logger interface {
+	Log(ctx context.Context, level Level, msg string, args ...interface{})
+}
+
+// LoggerFunc is an adapter to allow the use of ordinary functions as Logger
+type LoggerFunc func(ctx context.Context, level Level, msg string, args ...interface{})
+
+// Log calls f(ctx, level, msg, args...)
+func (f LoggerFunc) Log(ctx context.Context, level Level, msg string, args ...interface{}) {
+	f(ctx, level, msg, args...)
+}
+
+// SetLogger sets the logger of the bucket, records below the provided level
+// are dropped. Successful operations are logged at LevelDebug and failures at
+// LevelError. It should be called before the bucket is put to use.
+func (b *Bucket) SetLogger(logger Logger, level Level) {
+	b.logger = leveledLogger{logger: logger, level: level}
+}
+
+// Logger returns the logger of the bucket, it never returns nil and drops
+// every record if no logger has been set using SetLogger
+func (b *Bucket) Logger() Logger {
+	return b.logger
+}
+
+// logOp logs the result of the operation
+func (b *Bucket) logOp(ctx context.Context, op *Operation, d time.Duration, err error) {
+	if b.logger.logger == nil {
+		return
+	}
+	args := []interface{}{"bucket", b.name, "provider", b.provider.String(), "operation", string(op.Kind), "name", op.Name}
+	if op.Source != "" {
+		args = append(args, "source", op.Source)
+	}
+	args = append(args, "size", op.Size, "duration", d)
+	if err != nil {
+		b.logger.Log(ctx, LevelError, "bucket: operation failed", append(args, "error", err)...)
+		return
+	}
+	b.logger.Log(ctx, LevelDebug, "bucket: operation", args...)
+}
+
+// leveledLogger drops the records below level
+type leveledLogger struct {
+	logger Logger
+	level  Level
+}
+
+func (l leveledLogger) Log(ctx context.Context, level Level, msg string, args ...interface{}) {
+	if l.logger == nil || level < l.level {
+		return
+	}
+	l.logger.Log(ctx, level, msg, args...)
+}
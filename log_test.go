@@ -0,0 +1,75 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+type record struct {
+	level Level
+	msg   string
+	args  map[string]interface{}
+}
+
+type recordLogger []record
+
+func (l *recordLogger) Log(_ context.Context, level Level, msg string, args ...interface{}) {
+	rec := record{level: level, msg: msg, args: map[string]interface{}{}}
+	for i := 0; i+1 < len(args); i += 2 {
+		rec.args[fmt.Sprint(args[i])] = args[i+1]
+	}
+	*l = append(*l, rec)
+}
+
+func TestSetLogger(t *testing.T) {
+	ctx := context.Background()
+	t.Run("level-debug", func(t *testing.T) {
+		logger := &recordLogger{}
+		bucket := NewBucket("mem://")
+		bucket.SetLogger(logger, LevelDebug)
+		if _, err := bucket.WriteAll(ctx, "one.in", []byte("one.in")); err != nil {
+			t.Fatalf("WriteAll(one.in), got: %v \n", err)
+		}
+		if _, err := bucket.ReadAll(ctx, "two.in"); err == nil {
+			t.Fatal("ReadAll(two.in) should fail for missing file")
+		}
+		if len(*logger) != 2 {
+			t.Fatalf("Log(), got: %v records want: 2 \n", len(*logger))
+		}
+		rec := (*logger)[0]
+		if rec.level != LevelDebug || rec.args["operation"] != "write" || rec.args["name"] != "one.in" || rec.args["size"] != int64(6) {
+			t.Errorf("Log(write), got: %v \n", rec)
+		}
+		rec = (*logger)[1]
+		if rec.level != LevelError || rec.args["operation"] != "read" || rec.args["error"] == nil {
+			t.Errorf("Log(read), got: %v \n", rec)
+		}
+	})
+	t.Run("level-error", func(t *testing.T) {
+		logger := &recordLogger{}
+		bucket := NewBucket("mem://")
+		bucket.SetLogger(logger, LevelError)
+		if _, err := bucket.WriteAll(ctx, "one.in", []byte("one.in")); err != nil {
+			t.Fatalf("WriteAll(one.in), got: %v \n", err)
+		}
+		if len(*logger) != 0 {
+			t.Errorf("Log(), got: %v records want: 0 \n", len(*logger))
+		}
+	})
+	t.Run("open-failure", func(t *testing.T) {
+		logger := &recordLogger{}
+		bucket := NewBucket("file:///this/does/not/exist")
+		bucket.SetLogger(logger, LevelInfo)
+		if err := bucket.Open(); err == nil {
+			t.Fatal("Open() should fail for missing directory")
+		}
+		if len(*logger) != 1 || (*logger)[0].msg != "bucket: open failed" {
+			t.Errorf("Log(), got: %v \n", *logger)
+		}
+	})
+	t.Run("no-logger", func(t *testing.T) {
+		bucket := NewBucket("mem://")
+		bucket.Logger().Log(ctx, LevelError, "dropped")
+	})
+}
@@ -0,0 +1,261 @@
+// Package metrics records Prometheus-style metrics (counts, bytes, latency
+// histograms and error codes) of the upload.Bucket operations and of the
+// requests served by the pfsblob handler, and exposes them in the standard
+// text exposition format, without requiring a live monitoring service.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shivam010/upload"
+	"github.com/Shivam010/upload/internal/httpx"
+	"gocloud.dev/gcerrors"
+)
+
+// DefaultBuckets are the default latency histogram buckets, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry collects the metrics of the instrumented buckets and handlers
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+
+	ops         *family
+	opBytes     *family
+	opDuration  *family
+	reqs        *family
+	reqBytes    *family
+	reqDuration *family
+}
+
+// NewRegistry returns a new Registry, the latency histograms use the provided
+// buckets or DefaultBuckets if none are provided
+func NewRegistry(buckets ...float64) *Registry {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	r := &Registry{}
+	r.ops = r.register("upload_bucket_operations_total", "Total number of operations performed on buckets.",
+		"counter", nil, "provider", "bucket", "operation", "code")
+	r.opBytes = r.register("upload_bucket_operation_bytes_total", "Total number of bytes written or read from buckets.",
+		"counter", nil, "provider", "bucket", "operation")
+	r.opDuration = r.register("upload_bucket_operation_duration_seconds", "Latency of operations performed on buckets.",
+		"histogram", buckets, "provider", "bucket", "operation")
+	r.reqs = r.register("upload_http_requests_total", "Total number of http requests served from buckets.",
+		"counter", nil, "provider", "bucket", "method", "code")
+	r.reqBytes = r.register("upload_http_response_bytes_total", "Total number of bytes served from buckets over http.",
+		"counter", nil, "provider", "bucket", "method")
+	r.reqDuration = r.register("upload_http_request_duration_seconds", "Latency of http requests served from buckets.",
+		"histogram", buckets, "provider", "bucket", "method")
+	return r
+}
+
+// Instrument adds the metrics interceptor to the bucket
+func (r *Registry) Instrument(b *upload.Bucket) {
+	b.Use(r.Interceptor(b))
+}
+
+// Interceptor returns the upload.Interceptor recording the metrics of the
+// operations performed on the bucket b
+func (r *Registry) Interceptor(b *upload.Bucket) upload.Interceptor {
+	provider, name := b.Provider().String(), b.Name()
+	return func(next upload.Op) upload.Op {
+		return func(ctx context.Context, op *upload.Operation) error {
+			kind := string(op.Kind)
+			// kept aside, the inner interceptors may wrap the body in turn
+			var body *countingReader
+			if op.Kind == upload.OpWrite && op.Body != nil {
+				body = &countingReader{Reader: op.Body, done: func(n int64) {
+					r.add(r.opBytes, float64(n), provider, name, kind)
+				}}
+				op.Body = body
+			}
+
+			start := time.Now()
+			err := next(ctx, op)
+			r.observe(r.opDuration, time.Since(start).Seconds(), provider, name, kind)
+			r.add(r.ops, 1, provider, name, kind, gcerrors.Code(err).String())
+
+			if err == nil && op.Kind == upload.OpRead && op.Reader != nil {
+				op.Reader = &countingReader{Reader: op.Reader, closer: op.Reader, done: func(n int64) {
+					r.add(r.opBytes, float64(n), provider, name, kind)
+				}}
+			}
+			if body != nil {
+				body.finish()
+			}
+			return err
+		}
+	}
+}
+
+// InstrumentHandler wraps the handler serving the files of bucket b, e.g.
+// the one returned by handler.BucketRouteAndHandler, recording the metrics
+// of every request served
+func (r *Registry) InstrumentHandler(b *upload.Bucket, h http.HandlerFunc) http.HandlerFunc {
+	provider, name := b.Provider().String(), b.Name()
+	return func(w http.ResponseWriter, req *http.Request) {
+		rec := httpx.NewResponseRecorder(w)
+		start := time.Now()
+		h(rec, req)
+		r.observe(r.reqDuration, time.Since(start).Seconds(), provider, name, req.Method)
+		r.add(r.reqs, 1, provider, name, req.Method, strconv.Itoa(rec.Status))
+		r.add(r.reqBytes, float64(rec.Written), provider, name, req.Method)
+	}
+}
+
+// ServeHTTP implements http.Handler, writing all the metrics in the text
+// exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+// WriteTo writes all the metrics in the text exposition format to w
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	buf := &bytes.Buffer{}
+	r.mu.Lock()
+	for _, f := range r.families {
+		f.write(buf)
+	}
+	r.mu.Unlock()
+	return buf.WriteTo(w)
+}
+
+func (r *Registry) register(name, help, typ string, buckets []float64, labels ...string) *family {
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// add increments the counter f, with provided label values, by v
+func (r *Registry) add(f *family, v float64, values ...string) {
+	r.mu.Lock()
+	f.get(values).sum += v
+	r.mu.Unlock()
+}
+
+// observe records the value v in the histogram f, with provided label values
+func (r *Registry) observe(f *family, v float64, values ...string) {
+	r.mu.Lock()
+	s := f.get(values)
+	for i, le := range f.buckets {
+		if v <= le {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+	r.mu.Unlock()
+}
+
+// family is a metric with all of its labelled series
+type family struct {
+	name, help, typ string
+	labels          []string
+	buckets         []float64
+	series          map[string]*series
+}
+
+// series is a single labelled counter or histogram, for counters only sum is used
+type series struct {
+	values []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (f *family) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: values, counts: make([]uint64, len(f.buckets))}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) write(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.typ)
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := f.series[k]
+		labels := f.labelPairs(s.values)
+		if f.typ != "histogram" {
+			fmt.Fprintf(buf, "%s{%s} %s\n", f.name, labels, formatFloat(s.sum))
+			continue
+		}
+		for i, le := range f.buckets {
+			fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n", f.name, labels, formatFloat(le), s.counts[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", f.name, labels, s.count)
+		fmt.Fprintf(buf, "%s_sum{%s} %s\n", f.name, labels, formatFloat(s.sum))
+		fmt.Fprintf(buf, "%s_count{%s} %d\n", f.name, labels, s.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (f *family) labelPairs(values []string) string {
+	pairs := make([]string, len(f.labels))
+	for i, l := range f.labels {
+		pairs[i] = l + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingReader counts the bytes read and reports them once, when finished
+// or closed
+type countingReader struct {
+	io.Reader
+	closer io.Closer
+	n      int64
+	once   sync.Once
+	done   func(n int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	c.finish()
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
+
+func (c *countingReader) finish() {
+	c.once.Do(func() { c.done(c.n) })
+}
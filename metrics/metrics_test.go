@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Shivam010/upload"
+)
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	reg := NewRegistry()
+	bucket := upload.NewBucket("mem://")
+	reg.Instrument(bucket)
+
+	if _, err := bucket.WriteAll(ctx, "one.in", []byte("one.in")); err != nil {
+		t.Fatalf("WriteAll(one.in), got: %v \n", err)
+	}
+	if _, err := bucket.ReadAll(ctx, "one.in"); err != nil {
+		t.Fatalf("ReadAll(one.in), got: %v \n", err)
+	}
+	if _, err := bucket.ReadAll(ctx, "two.in"); err == nil {
+		t.Fatal("ReadAll(two.in) should fail for missing file")
+	}
+
+	handler := reg.InstrumentHandler(bucket, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/two.in", nil))
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := rec.Body.String()
+	for _, want := range []string{
+		`# TYPE upload_bucket_operations_total counter`,
+		`upload_bucket_operations_total{provider="In-Memory",bucket="",operation="write",code="OK"} 1`,
+		`upload_bucket_operations_total{provider="In-Memory",bucket="",operation="read",code="NotFound"} 1`,
+		`upload_bucket_operation_bytes_total{provider="In-Memory",bucket="",operation="write"} 6`,
+		`upload_bucket_operation_bytes_total{provider="In-Memory",bucket="",operation="read"} 6`,
+		`# TYPE upload_bucket_operation_duration_seconds histogram`,
+		`upload_bucket_operation_duration_seconds_bucket{provider="In-Memory",bucket="",operation="read",le="+Inf"} 2`,
+		`upload_bucket_operation_duration_seconds_count{provider="In-Memory",bucket="",operation="write"} 1`,
+		`upload_http_requests_total{provider="In-Memory",bucket="",method="GET",code="404"} 1`,
+		`upload_http_response_bytes_total{provider="In-Memory",bucket="",method="GET"} 10`,
+		`upload_http_request_duration_seconds_count{provider="In-Memory",bucket="",method="GET"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("WriteTo(), want: %v in: \n%v", want, out)
+		}
+	}
+}
+
+func TestRegistryEncrypted(t *testing.T) {
+	ctx := context.Background()
+	reg := NewRegistry()
+	bucket := upload.NewBucket("mem://")
+	keys := upload.NewKeyring()
+	if err := keys.Add("k1", make([]byte, 32)); err != nil {
+		t.Fatalf("Add(k1), got: %v \n", err)
+	}
+	bucket.SetEncryption(keys, "k1")
+	reg.Instrument(bucket)
+
+	// the plaintext is counted, even though the body is encrypted inside
+	if _, err := bucket.WriteAll(ctx, "one.in", []byte("one.in")); err != nil {
+		t.Fatalf("WriteAll(one.in), got: %v \n", err)
+	}
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `upload_bucket_operation_bytes_total{provider="In-Memory",bucket="",operation="write"} 6`
+	if out := rec.Body.String(); !strings.Contains(out, want) {
+		t.Errorf("WriteTo(), want: %v in: \n%v", want, out)
+	}
+}
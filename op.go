@@ -0,0 +1,224 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// OpKind describes the kind of operation performed on the bucket
+type OpKind string
+
+const (
+	OpWrite   OpKind = "write"
+	OpRead    OpKind = "read"
+	OpDelete  OpKind = "delete"
+	OpStat    OpKind = "stat"
+	OpList    OpKind = "list"
+	OpCopy    OpKind = "copy"
+	OpCompose OpKind = "compose"
+)
+
+// Operation describes a single call made on the bucket. It is passed along
+// the interceptor chain and at last executed against the underlying blob bucket,
+// so any change made by an interceptor (e.g. to the Name) is honoured. The
+// names returned to the caller (the links of Upload and Copy, and the keys
+// of List) remain the ones of the caller, e.g. without the tenant prefix.
+type Operation struct {
+	// Kind of the operation
+	Kind OpKind
+	// Name of the file, it is the destination name for OpCopy and OpCompose
+	// and the prefix for OpList
+	Name string
+	// Source is the name of the file being copied, only used for OpCopy
+	Source string
+	// Sources are the names of the files concatenated, in order, into the
+	// file, only used for OpCompose. Interceptors changing the Name must
+	// change them alike.
+	Sources []string
+	// Size is the number of bytes of the content, for OpWrite it is set
+	// before execution (-1 if unknown) and for OpRead and OpStat it is set
+	// to the size of the file after execution
+	Size int64
+	// Offset and Length of the content to be read, only used for OpRead,
+	// a negative Length reads till the end of the file
+	Offset, Length int64
+
+	// Body is the content to be written, only used for OpWrite
+	Body io.Reader
+	// Options of the file being written, only used for OpWrite, can be nil.
+	// Interceptors changing the Body must reset its ContentMD5.
+	Options *WriteOptions
+	// Reader is the content of the file, set after execution of OpRead
+	Reader io.ReadCloser
+	// Attributes of the file, set after execution of OpStat. For OpRead, if
+	// set before execution, the read is pinned to the version of the file
+	// having these attributes, and fails with ErrObjectChanged otherwise.
+	Attributes *blob.Attributes
+	// Objects found under the prefix, set after execution of OpList
+	Objects []*blob.ListObject
+	// Delimiter groups the files of OpList sharing the part of their name
+	// from the prefix to it (e.g. "/" for the sub-directories) as a single
+	// object, having IsDir set, the files are not grouped if empty
+	Delimiter string
+	// PageSize, if positive, limits OpList to a page of Objects, the one of
+	// PageToken (the first page if nil), and NextPageToken is set after
+	// execution to the token of the next page, nil for the last one
+	PageSize                 int
+	PageToken, NextPageToken []byte
+}
+
+// callerObjects returns the Objects listed under the prefix of the caller,
+// the prefix rewritten by the interceptors is not exposed
+func (op *Operation) callerObjects(prefix string) []*blob.ListObject {
+	if op.Name != prefix {
+		for _, obj := range op.Objects {
+			if strings.HasPrefix(obj.Key, op.Name) {
+				obj.Key = prefix + obj.Key[len(op.Name):]
+			}
+		}
+	}
+	return op.Objects
+}
+
+// Op executes an operation on the bucket
+type Op func(ctx context.Context, op *Operation) error
+
+// Interceptor wraps an Op to compose cross-cutting behaviour (logging,
+// metrics, auth checks, etc.) around every operation on the bucket
+type Interceptor func(next Op) Op
+
+// Use appends the interceptors to the chain of the bucket, the first
+// interceptor is the outermost one. It should be called before the bucket
+// is put to use, as it is not safe to call concurrently with operations.
+func (b *Bucket) Use(interceptors ...Interceptor) {
+	b.interceptors = append(b.interceptors, interceptors...)
+}
+
+// do opens the bucket if required and executes the operation through the
+// interceptor chain
+func (b *Bucket) do(ctx context.Context, op *Operation) error {
+	if err := b.open(ctx); err != nil {
+		return err
+	}
+	next := Op(b.exec)
+	if b.keys != nil {
+		next = b.encrypt(next)
+	}
+	for i := len(b.interceptors) - 1; i >= 0; i-- {
+		next = b.interceptors[i](next)
+	}
+	ctx, cancel := withTimeout(ctx, b.timeouts.timeout(op.Kind))
+	start := time.Now()
+	err := next(ctx, op)
+	b.logOp(ctx, op, time.Since(start), err)
+	if err == nil && op.Kind == OpRead && op.Reader != nil {
+		// the content is read after the return, so cancel only once it is closed
+		op.Reader = &cancelReader{ReadCloser: op.Reader, cancel: cancel}
+	} else {
+		cancel()
+	}
+	return err
+}
+
+// exec executes the operation against the underlying blob bucket
+func (b *Bucket) exec(ctx context.Context, op *Operation) (err error) {
+	switch op.Kind {
+	case OpWrite:
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		wopts := op.Options.writerOptions()
+		if op.Options.hasPreconditions() {
+			unlock, err := b.lockWrite(ctx, op.Name)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+			if wopts, err = b.checkPreconditions(ctx, op.Name, op.Options); err != nil {
+				return err
+			}
+		}
+		body, local := op.Body, (*localWrite)(nil)
+		if path := b.localPath(op.Name); path != "" {
+			local = &localWrite{path: path, journal: b.tempJournal()}
+			defer local.done()
+			body, wopts = local.prepare(body, wopts)
+		}
+		w, err := b.bucket.NewWriter(ctx, op.Name, wopts)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, &contextReader{ctx: ctx, r: body})
+		if err == nil && local != nil {
+			err = local.sync()
+		}
+		if err != nil {
+			// cancelling the context aborts the write, so that the
+			// partially written content is not committed by w.Close()
+			cancel()
+			_ = w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return preconditionError(op.Name, err)
+		}
+		if local != nil {
+			if err := local.commit(); err != nil {
+				b.logger.Log(ctx, LevelWarn, "bucket: syncing the written file failed", "bucket", b.name, "name", op.Name, "error", err)
+			}
+		}
+		return nil
+	case OpRead:
+		r, err := b.bucket.NewRangeReader(ctx, op.Name, op.Offset, op.Length, pinnedReaderOptions(op.Attributes))
+		if err != nil {
+			if op.Attributes != nil && isChanged(err) {
+				return ErrObjectChanged
+			}
+			return err
+		}
+		if a := op.Attributes; a != nil && (r.Size() != a.Size || !a.ModTime.IsZero() && !r.ModTime().Equal(a.ModTime)) {
+			_ = r.Close()
+			return ErrObjectChanged
+		}
+		op.Reader, op.Size = r, r.Size()
+		return nil
+	case OpDelete:
+		return b.bucket.Delete(ctx, op.Name)
+	case OpStat:
+		if op.Attributes, err = b.bucket.Attributes(ctx, op.Name); err != nil {
+			return err
+		}
+		op.Size = op.Attributes.Size
+		return nil
+	case OpList:
+		opts := &blob.ListOptions{Prefix: op.Name, Delimiter: op.Delimiter}
+		if op.PageSize > 0 {
+			token := op.PageToken
+			if len(token) == 0 {
+				token = blob.FirstPageToken
+			}
+			op.Objects, op.NextPageToken, err = b.bucket.ListPage(ctx, token, op.PageSize, opts)
+			return err
+		}
+		iter := b.bucket.List(opts)
+		for {
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			op.Objects = append(op.Objects, obj)
+		}
+	case OpCopy:
+		return b.bucket.Copy(ctx, op.Name, op.Source, nil)
+	case OpCompose:
+		return b.compose(ctx, op.Name, op.Sources)
+	}
+	return fmt.Errorf("bucket: unknown operation %q", op.Kind)
+}
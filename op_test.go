@@ -0,0 +1,121 @@
+package upload
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestUse(t *testing.T) {
+	ctx := context.Background()
+	bucket := NewBucket("mem://")
+	var calls []string
+	bucket.Use(
+		func(next Op) Op {
+			return func(ctx context.Context, op *Operation) error {
+				calls = append(calls, "outer:"+string(op.Kind))
+				err := next(ctx, op)
+				calls = append(calls, "outer:done")
+				return err
+			}
+		},
+		// tenant prefixing
+		func(next Op) Op {
+			return func(ctx context.Context, op *Operation) error {
+				calls = append(calls, "inner:"+string(op.Kind))
+				op.Name = "tenant/" + op.Name
+				if op.Source != "" {
+					op.Source = "tenant/" + op.Source
+				}
+				return next(ctx, op)
+			}
+		},
+	)
+
+	link, err := bucket.WriteAll(ctx, "one.in", []byte("one.in"))
+	if err != nil {
+		t.Fatalf("WriteAll(one.in), got: %v \n", err)
+	}
+	if link != "one.in" {
+		t.Errorf("WriteAll(one.in), got: %v want: %v \n", link, "one.in")
+	}
+	want := []string{"outer:write", "inner:write", "outer:done"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Use(), got: %v want: %v \n", calls, want)
+	}
+	if con, err := bucket.ReadAll(ctx, bucket.GetName(link)); err != nil || string(con) != "one.in" {
+		t.Errorf("ReadAll(GetName(%v)), got: %s, %v want: %v \n", link, con, err, "one.in")
+	}
+
+	if _, err := bucket.Copy(ctx, "two.in", "one.in"); err != nil {
+		t.Errorf("Copy(two.in, one.in), got: %v \n", err)
+	}
+	attrs, err := bucket.Attributes(ctx, "two.in")
+	if err != nil {
+		t.Fatalf("Attributes(two.in), got: %v \n", err)
+	}
+	if attrs.Size != int64(len("one.in")) {
+		t.Errorf("Attributes(two.in).Size, got: %v want: %v \n", attrs.Size, len("one.in"))
+	}
+	list, err := bucket.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List(), got: %v \n", err)
+	}
+	var names []string
+	for _, obj := range list {
+		names = append(names, obj.Key)
+	}
+	if got := strings.Join(names, ","); got != "one.in,two.in" {
+		t.Errorf("List(), got: %v want: %v \n", got, "one.in,two.in")
+	}
+	names = nil
+	for token, pages := []byte(nil), 0; pages == 0 || token != nil; pages++ {
+		var page []*blob.ListObject
+		if page, token, err = bucket.ListPage(ctx, "", "/", token, 1); err != nil || len(page) != 1 || pages > 1 {
+			t.Fatalf("ListPage(%v), got: %v, %v want: a single object \n", pages, len(page), err)
+		}
+		names = append(names, page[0].Key)
+	}
+	if got := strings.Join(names, ","); got != "one.in,two.in" {
+		t.Errorf("ListPage(), got: %v want: %v \n", got, "one.in,two.in")
+	}
+	con, err := bucket.ReadAll(ctx, "two.in")
+	if err != nil {
+		t.Fatalf("ReadAll(two.in), got: %v \n", err)
+	}
+	if string(con) != "one.in" {
+		t.Errorf("Content(two.in), got: %s want: %v \n", con, "one.in")
+	}
+	if err := bucket.Delete(ctx, "one.in"); err != nil {
+		t.Errorf("Delete(one.in), got: %v \n", err)
+	}
+	if _, err := bucket.Attributes(ctx, "one.in"); err == nil {
+		t.Error("Attributes(one.in) should fail for deleted file")
+	}
+}
+
+func TestUseSize(t *testing.T) {
+	ctx := context.Background()
+	bucket := NewBucket("mem://")
+	sizes := map[OpKind]int64{}
+	bucket.Use(func(next Op) Op {
+		return func(ctx context.Context, op *Operation) error {
+			err := next(ctx, op)
+			sizes[op.Kind] = op.Size
+			return err
+		}
+	})
+	if _, err := bucket.WriteAll(ctx, "one.in", []byte("one.in")); err != nil {
+		t.Fatalf("WriteAll(one.in), got: %v \n", err)
+	}
+	if _, err := bucket.ReadAll(ctx, "one.in"); err != nil {
+		t.Fatalf("ReadAll(one.in), got: %v \n", err)
+	}
+	want := map[OpKind]int64{OpWrite: 6, OpRead: 6}
+	if !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Operation.Size, got: %v want: %v \n", sizes, want)
+	}
+}
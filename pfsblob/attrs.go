@@ -0,0 +1,68 @@
+package pfsblob
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// AttrsExt is the extension of the sidecar files, in which the file system
+// blob stores the attributes of the files
+const AttrsExt = ".attrs"
+
+// tempPrefix is the prefix of the temporary files, in which the file system
+// blob writes the content before renaming it to the actual file
+const tempPrefix = "fileblob"
+
+// Attributes are the attributes of a file, stored in its sidecar file
+type Attributes struct {
+	CacheControl       string            `json:"user.cache_control"`
+	ContentDisposition string            `json:"user.content_disposition"`
+	ContentEncoding    string            `json:"user.content_encoding"`
+	ContentLanguage    string            `json:"user.content_language"`
+	ContentType        string            `json:"user.content_type"`
+	Metadata           map[string]string `json:"user.metadata"`
+	MD5                []byte            `json:"md5"`
+}
+
+// DecodeAttributes decodes the attributes from the content of a sidecar file
+func DecodeAttributes(r io.Reader) (*Attributes, error) {
+	attrs := &Attributes{}
+	if err := json.NewDecoder(r).Decode(attrs); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// IsInternal reports whether the file at name is a sidecar or a temporary
+// file of the file system blob, which are not meant to be served
+func IsInternal(name string) bool {
+	return strings.HasSuffix(name, AttrsExt) || IsTemp(name)
+}
+
+// IsCurrent reports whether the sidecar attributes are of the same write as
+// the content of the file, given the info of both. The sidecar is written
+// before the content is renamed into place, so until both are, the sidecar
+// of a write can be read with the content of another: the buckets of the
+// upload package give the sidecar the modification time of the content, once
+// renamed, and the attributes (e.g. the MD5 hash) are only trusted if they match.
+func IsCurrent(content, sidecar os.FileInfo) bool {
+	return content.ModTime().Equal(sidecar.ModTime())
+}
+
+// IsTemp reports whether the file at name is a temporary file of the file
+// system blob, in which the content is written before being renamed
+func IsTemp(name string) bool {
+	base := path.Base(name)
+	if !strings.HasPrefix(base, tempPrefix) || len(base) == len(tempPrefix) {
+		return false
+	}
+	for _, c := range base[len(tempPrefix):] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shivam010/upload"
+)
+
+var (
+	// ErrUnauthorized is returned by the authorisers for requests without valid
+	// credentials, such requests are responded with 401 Unauthorized
+	ErrUnauthorized = errors.New("handler: unauthorized")
+	// ErrForbidden is returned by the authorisers for requests not allowed to
+	// access the file, such requests (and the ones rejected with any other
+	// error) are responded with 403 Forbidden
+	ErrForbidden = errors.New("handler: forbidden")
+)
+
+// Authorizer authorises the requests made for the file name of the bucket,
+// a non-nil error rejects the request
+type Authorizer interface {
+	Authorize(r *http.Request, name string) error
+}
+
+// AuthorizerFunc is an adapter to allow the use of ordinary functions as Authorizer
+type AuthorizerFunc func(r *http.Request, name string) error
+
+// Authorize calls f(r, name)
+func (f AuthorizerFunc) Authorize(r *http.Request, name string) error {
+	return f(r, name)
+}
+
+var (
+	// Allow authorises every request
+	Allow Authorizer = AuthorizerFunc(func(*http.Request, string) error { return nil })
+	// Deny rejects every request
+	Deny Authorizer = AuthorizerFunc(func(*http.Request, string) error { return ErrForbidden })
+)
+
+// authorize authorises the request using a, responding with the error and
+// returning false if the request is rejected
+func authorize(w http.ResponseWriter, r *http.Request, a Authorizer, name string) bool {
+	if a == nil {
+		return true
+	}
+	err := a.Authorize(r, name)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, ErrUnauthorized) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="upload"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return false
+}
+
+// isRead reports whether the request only reads the files
+func isRead(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+}
+
+// BearerToken returns the Authorizer allowing the requests with any of the
+// tokens in the "Authorization: Bearer <token>" header
+func BearerToken(tokens ...string) Authorizer {
+	return AuthorizerFunc(func(r *http.Request, _ string) error {
+		auth := r.Header.Get("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			return ErrUnauthorized
+		}
+		for _, token := range tokens {
+			if subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(token)) == 1 {
+				return nil
+			}
+		}
+		return ErrUnauthorized
+	})
+}
+
+// Rule authorises the requests for the files under Prefix
+type Rule struct {
+	// Prefix of the file names, relative to the route, e.g. "users/"
+	Prefix string
+	// Read authorises the GET and HEAD requests, they are denied if nil
+	Read Authorizer
+	// Write authorises the PUT, POST and DELETE requests, they are denied if nil
+	Write Authorizer
+}
+
+// Rules is the Authorizer using the rule with the longest prefix matching the
+// file name, the requests for the files without any matching rule are denied
+type Rules []Rule
+
+// Authorize implements Authorizer
+func (rs Rules) Authorize(r *http.Request, name string) error {
+	var rule *Rule
+	for i := range rs {
+		if strings.HasPrefix(name, rs[i].Prefix) && (rule == nil || len(rs[i].Prefix) > len(rule.Prefix)) {
+			rule = &rs[i]
+		}
+	}
+	if rule == nil {
+		return ErrForbidden
+	}
+	a := rule.Write
+	if isRead(r) {
+		a = rule.Read
+	}
+	if a == nil {
+		return ErrForbidden
+	}
+	return a.Authorize(r, name)
+}
+
+// URLSigner signs the links of the files using HMAC-SHA256, and authorises
+// the requests made using the signed links, until they expire
+type URLSigner struct {
+	key []byte
+}
+
+// NewURLSigner returns the URLSigner using the secret key
+func NewURLSigner(key []byte) *URLSigner {
+	return &URLSigner{key: key}
+}
+
+// Sign returns the link of the file name in the bucket, signed for the
+// requests with the method until expires
+func (s *URLSigner) Sign(b *upload.Bucket, name, method string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("signature", s.signature(method, name, exp))
+	return b.GetUrl(name) + "?" + q.Encode()
+}
+
+// Authorize implements Authorizer, allowing the requests with a valid and
+// unexpired signature
+func (s *URLSigner) Authorize(r *http.Request, name string) error {
+	q := r.URL.Query()
+	exp, sig := q.Get("expires"), q.Get("signature")
+	if exp == "" || sig == "" {
+		return ErrUnauthorized
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return ErrUnauthorized
+	}
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signature(method, name, exp))) {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+func (s *URLSigner) signature(method, name, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(method + "\n" + name + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
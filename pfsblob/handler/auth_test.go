@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthorizer(t *testing.T) {
+	buck, _ := newTestBucket(t)
+	signer := NewURLSigner([]byte("secret"))
+	_, handler, err := BucketRouteAndHandlerWithOptions(buck, &HandlerOptions{
+		AllowUpload: true,
+		Authorizer: Rules{
+			{Prefix: "", Read: Allow, Write: BearerToken("admin")},
+			{Prefix: "private/", Read: signer, Write: Deny},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandlerWithOptions(), got: %v \n", err)
+	}
+
+	put := func(target, token string) int {
+		req := httptest.NewRequest(http.MethodPut, target, bytes.NewBufferString("content"))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+	if code := put("/srv/dir/two.txt", ""); code != http.StatusUnauthorized {
+		t.Errorf("PUT /srv/dir/two.txt, got: %v want: %v \n", code, http.StatusUnauthorized)
+	}
+	if code := put("/srv/dir/two.txt", "guest"); code != http.StatusUnauthorized {
+		t.Errorf("PUT /srv/dir/two.txt, got: %v want: %v \n", code, http.StatusUnauthorized)
+	}
+	if code := put("/srv/dir/two.txt", "admin"); code != http.StatusCreated {
+		t.Errorf("PUT /srv/dir/two.txt, got: %v want: %v \n", code, http.StatusCreated)
+	}
+	if code := put("/srv/private/one.txt", "admin"); code != http.StatusForbidden {
+		t.Errorf("PUT /srv/private/one.txt, got: %v want: %v \n", code, http.StatusForbidden)
+	}
+
+	if rec := serve(t, handler, http.MethodGet, "/srv/dir/two.txt", nil); rec.Code != http.StatusOK {
+		t.Errorf("GET /srv/dir/two.txt, got: %v want: %v \n", rec.Code, http.StatusOK)
+	}
+	if _, err := buck.WriteAll(context.Background(), "private/one.txt", []byte("private")); err != nil {
+		t.Fatalf("WriteAll(private/one.txt), got: %v \n", err)
+	}
+	if rec := serve(t, handler, http.MethodGet, "/srv/private/one.txt", nil); rec.Code != http.StatusUnauthorized || rec.Header().Get("Cache-Control") != "" {
+		t.Errorf("GET /srv/private/one.txt, got: %v (%v) want: %v, not cached \n", rec.Code, rec.Header().Get("Cache-Control"), http.StatusUnauthorized)
+	}
+
+	signed := func(name, method string, expires time.Time) string {
+		u, err := url.Parse(signer.Sign(buck, name, method, expires))
+		if err != nil {
+			t.Fatalf("Sign(%v), got: %v \n", name, err)
+		}
+		return u.RequestURI()
+	}
+	tests := []struct {
+		target string
+		status int
+	}{
+		{target: signed("private/one.txt", http.MethodGet, time.Now().Add(time.Minute)), status: http.StatusOK},
+		{target: signed("private/one.txt", http.MethodGet, time.Now().Add(-time.Minute)), status: http.StatusUnauthorized},
+		{target: signed("private/one.txt", http.MethodPut, time.Now().Add(time.Minute)), status: http.StatusUnauthorized},
+		{target: signed("private/two.txt", http.MethodGet, time.Now().Add(time.Minute)), status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := serve(t, handler, http.MethodGet, tt.target, nil)
+		if rec.Code != tt.status {
+			t.Errorf("GET %v, got: %v want: %v \n", tt.target, rec.Code, tt.status)
+		}
+		// the authorised files are never cached by the shared caches
+		want, vary := "", ""
+		if rec.Code == http.StatusOK {
+			want, vary = "private", "Authorization"
+		}
+		if got := rec.Header().Get("Cache-Control"); got != want {
+			t.Errorf("GET %v, Cache-Control got: %v want: %v \n", tt.target, got, want)
+		}
+		if got := strings.Join(rec.Header()["Vary"], ", "); got != vary {
+			t.Errorf("GET %v, Vary got: %v want: %v \n", tt.target, got, vary)
+		}
+	}
+
+	opts := DefaultHandlerOptions()
+	opts.Authorizer = BearerToken("token")
+	_, handler, _ = BucketRouteAndHandlerWithOptions(buck, opts)
+	rec := serve(t, handler, http.MethodGet, "/srv/dir/one.txt", map[string]string{"Authorization": "Bearer token"})
+	if got := rec.Header().Get("Cache-Control"); rec.Code != http.StatusOK || got != "private, max-age=31536000, immutable" {
+		t.Errorf("GET /srv/dir/one.txt, got: %v (%v) want: %v (private, max-age=31536000, immutable) \n", rec.Code, got, http.StatusOK)
+	}
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressibleTypes are the content types compressed on the fly, if
+// HandlerOptions.CompressibleTypes is empty
+var DefaultCompressibleTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// precompressed are the extensions of the precompressed variants of a file,
+// in the order of preference, with their content encoding
+var precompressed = []struct{ ext, encoding string }{
+	{ext: ".br", encoding: "br"},
+	{ext: ".gz", encoding: "gzip"},
+}
+
+// acceptsEncoding reports whether the client accepts the content encoding
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, field := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params := field, ""
+		if i := strings.Index(field, ";"); i >= 0 {
+			coding, params = field[:i], field[i+1:]
+		}
+		if !strings.EqualFold(strings.TrimSpace(coding), encoding) {
+			continue
+		}
+		params = strings.TrimSpace(params)
+		if strings.HasPrefix(params, "q=") {
+			q, err := strconv.ParseFloat(params[2:], 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
+
+// servePrecompressed serves the precompressed variant of the file, stored
+// alongside it with the ".br" or ".gz" extension, if enabled in the options and
+// the client accepts its encoding. It returns false if there is no such variant.
+func (s *server) servePrecompressed(w http.ResponseWriter, r *http.Request) bool {
+	name := s.name(r)
+	if !s.opts.Precompressed || name == "" || strings.HasSuffix(r.URL.Path, "/") || s.isInternal(name) {
+		return false
+	}
+	for _, p := range precompressed {
+		if !acceptsEncoding(r, p.encoding) {
+			continue
+		}
+		attrs, err := s.buck.Attributes(r.Context(), name+p.ext)
+		if err != nil {
+			continue
+		}
+		// the variant is served as the original file, only encoded
+		variant := *attrs
+		variant.ContentType = mime.TypeByExtension(path.Ext(name))
+		if orig, err := s.buck.Attributes(r.Context(), name); err == nil {
+			variant.ContentType = orig.ContentType
+		}
+		variant.ContentEncoding = p.encoding
+		s.serveContent(w, r, name+p.ext, &variant)
+		return true
+	}
+	return false
+}
+
+// compressWriter gzip-compresses the response on the fly, if its status,
+// content type and length allow it, it must be closed once served
+type compressWriter struct {
+	http.ResponseWriter
+	opts    *HandlerOptions
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if !c.decided {
+		c.decided = true
+		if c.compressible(code) {
+			h := c.Header()
+			h.Del("Content-Length")
+			h.Del("Accept-Ranges")
+			h.Set("Content-Encoding", "gzip")
+			// the compressed content is not byte-identical, but the
+			// weak ETag still validates the cached responses
+			if tag := h.Get("ETag"); tag != "" && !strings.HasPrefix(tag, "W/") {
+				h.Set("ETag", "W/"+tag)
+			}
+			c.gz = gzip.NewWriter(c.ResponseWriter)
+		}
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.decided {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.gz != nil {
+		return c.gz.Write(p)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// Close flushes the compressed content
+func (c *compressWriter) Close() error {
+	if c.gz != nil {
+		return c.gz.Close()
+	}
+	return nil
+}
+
+func (c *compressWriter) compressible(code int) bool {
+	h := c.Header()
+	if code != http.StatusOK || h.Get("Content-Encoding") != "" {
+		return false
+	}
+	types := c.opts.CompressibleTypes
+	if len(types) == 0 {
+		types = DefaultCompressibleTypes
+	}
+	if !matchContentType(types, h.Get("Content-Type")) {
+		return false
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		size, err := strconv.ParseInt(cl, 10, 64)
+		return err == nil && size >= c.opts.CompressMinSize
+	}
+	return true
+}
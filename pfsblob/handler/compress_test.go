@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Shivam010/upload"
+)
+
+func TestPrecompressed(t *testing.T) {
+	buck, _ := newTestBucket(t)
+	for name, content := range map[string]string{
+		"app.js":    "console.log(1)",
+		"app.js.br": "brotli",
+		"app.js.gz": "gzip",
+		"lib.js.gz": "gzip",
+	} {
+		if _, err := buck.WriteAll(context.Background(), name, []byte(content)); err != nil {
+			t.Fatalf("WriteAll(%v), got: %v \n", name, err)
+		}
+	}
+	// the variants are served with the content type of the original file
+	text := "text/plain; charset=utf-8"
+	_, handler, err := BucketRouteAndHandlerWithOptions(buck, &HandlerOptions{Precompressed: true})
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandlerWithOptions(), got: %v \n", err)
+	}
+
+	tests := []struct {
+		target   string
+		accept   string
+		body     string
+		encoding string
+		typ      string
+	}{
+		{target: "/srv/app.js", body: "console.log(1)", typ: text},
+		{target: "/srv/app.js", accept: "gzip, deflate, br", body: "brotli", encoding: "br", typ: text},
+		{target: "/srv/app.js", accept: "gzip, br;q=0", body: "gzip", encoding: "gzip", typ: text},
+		{target: "/srv/app.js", accept: "deflate", body: "console.log(1)", typ: text},
+		{target: "/srv/lib.js", accept: "br, gzip", body: "gzip", encoding: "gzip", typ: mime.TypeByExtension(".js")},
+		{target: "/srv/dir/one.txt", accept: "br, gzip", body: "one.txt", typ: text},
+	}
+	for _, tt := range tests {
+		rec := serve(t, handler, http.MethodGet, tt.target, map[string]string{"Accept-Encoding": tt.accept})
+		if rec.Code != http.StatusOK || rec.Body.String() != tt.body {
+			t.Errorf("GET %v (%v), got: %v %s want: %v %v \n", tt.target, tt.accept, rec.Code, rec.Body, http.StatusOK, tt.body)
+		}
+		if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("GET %v (%v), Content-Encoding got: %v want: %v \n", tt.target, tt.accept, got, tt.encoding)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("GET %v (%v), Vary got: %v want: Accept-Encoding \n", tt.target, tt.accept, got)
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.typ {
+			t.Errorf("GET %v (%v), Content-Type got: %v want: %v \n", tt.target, tt.accept, got, tt.typ)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	buck, _ := newTestBucket(t)
+	large := strings.Repeat("compressible ", 100)
+	if _, err := buck.WriteAll(context.Background(), "large.txt", []byte(large)); err != nil {
+		t.Fatalf("WriteAll(large.txt), got: %v \n", err)
+	}
+	opts := &upload.WriteOptions{ContentType: "application/octet-stream"}
+	if _, err := buck.Upload(context.Background(), "large.bin", strings.NewReader(large), opts); err != nil {
+		t.Fatalf("Upload(large.bin), got: %v \n", err)
+	}
+	_, handler, err := BucketRouteAndHandlerWithOptions(buck, &HandlerOptions{Compress: true, CompressMinSize: 100})
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandlerWithOptions(), got: %v \n", err)
+	}
+
+	tests := []struct {
+		target     string
+		header     map[string]string
+		status     int
+		compressed bool
+	}{
+		{target: "/srv/large.txt", header: map[string]string{"Accept-Encoding": "gzip"}, status: http.StatusOK, compressed: true},
+		{target: "/srv/large.txt", status: http.StatusOK},
+		{target: "/srv/large.txt", header: map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-9"}, status: http.StatusPartialContent},
+		{target: "/srv/large.bin", header: map[string]string{"Accept-Encoding": "gzip"}, status: http.StatusOK},
+		{target: "/srv/dir/one.txt", header: map[string]string{"Accept-Encoding": "gzip"}, status: http.StatusOK},
+		{target: "/srv/dir/two.txt", header: map[string]string{"Accept-Encoding": "gzip"}, status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := serve(t, handler, http.MethodGet, tt.target, tt.header)
+		if rec.Code != tt.status {
+			t.Errorf("GET %v, got: %v want: %v \n", tt.target, rec.Code, tt.status)
+		}
+		if compressed := rec.Header().Get("Content-Encoding") == "gzip"; compressed != tt.compressed {
+			t.Errorf("GET %v, compressed got: %v want: %v \n", tt.target, compressed, tt.compressed)
+		}
+		if !tt.compressed {
+			continue
+		}
+		if tag := rec.Header().Get("ETag"); !strings.HasPrefix(tag, "W/") {
+			t.Errorf("GET %v, ETag got: %v want weak \n", tt.target, tag)
+		}
+		gz, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("GET %v, gzip got: %v \n", tt.target, err)
+		}
+		body, err := ioutil.ReadAll(gz)
+		if err != nil || string(body) != large {
+			t.Errorf("GET %v, got: %s (%v) want: %v \n", tt.target, body, err, large)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCORSHeaders are the request headers allowed in the cross-origin
+// requests, if CORSOptions.AllowedHeaders is empty
+var DefaultCORSHeaders = []string{"Authorization", "Content-Type", "Range", "If-Match", "If-None-Match"}
+
+// CORSOptions configures the cross-origin requests served by the handler
+type CORSOptions struct {
+	// AllowedOrigins are the origins allowed to make requests, e.g.
+	// "https://example.com", "*" allows every origin
+	AllowedOrigins []string
+	// AllowedMethods are the methods allowed in the requests, the methods
+	// enabled by the HandlerOptions are allowed if empty
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed in the requests, the
+	// DefaultCORSHeaders are allowed if empty
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers exposed to the clients, besides
+	// the CORS-safelisted ones, e.g. "ETag" or "Location"
+	ExposedHeaders []string
+	// MaxAge is how long the clients can cache the preflight responses, it is
+	// not set if zero
+	MaxAge time.Duration
+	// AllowCredentials allows the requests with cookies or the Authorization
+	// header, the request origin is then echoed even if "*" is allowed
+	AllowCredentials bool
+}
+
+// allowsOrigin reports whether the origin is allowed
+func (c *CORSOptions) allowsOrigin(origin string) bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin header
+func (c *CORSOptions) allowOrigin(origin string) string {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" && !c.AllowCredentials {
+			return "*"
+		}
+	}
+	return origin
+}
+
+// serveCORS adds the CORS headers to the response of the cross-origin request,
+// and answers it, returning true, if it is a preflight request
+func (s *server) serveCORS(w http.ResponseWriter, r *http.Request) bool {
+	c, origin := s.opts.CORS, r.Header.Get("Origin")
+	if c == nil {
+		return false
+	}
+	h := w.Header()
+	// the responses depend on the origin, even the ones to the same-origin
+	// requests, unless every origin is allowed the same way
+	if c.allowOrigin(origin) != "*" {
+		h.Add("Vary", "Origin")
+	}
+	if origin == "" {
+		return false
+	}
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if !c.allowsOrigin(origin) {
+		if preflight {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		}
+		return preflight
+	}
+	if !preflight {
+		h.Set("Access-Control-Allow-Origin", c.allowOrigin(origin))
+		if c.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if len(c.ExposedHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+		}
+		return false
+	}
+
+	methods, headers := c.AllowedMethods, c.AllowedHeaders
+	if len(methods) == 0 {
+		methods = s.allowedMethods()
+	}
+	if len(headers) == 0 {
+		headers = DefaultCORSHeaders
+	}
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	if !contains(methods, r.Header.Get("Access-Control-Request-Method"), false) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return true
+	}
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if header = strings.TrimSpace(header); header != "" && !contains(headers, header, true) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return true
+		}
+	}
+	h.Set("Access-Control-Allow-Origin", c.allowOrigin(origin))
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// contains reports whether the values contain v, ignoring the case if fold
+func contains(values []string, v string, fold bool) bool {
+	for _, val := range values {
+		if val == v || fold && strings.EqualFold(val, v) {
+			return true
+		}
+	}
+	return false
+}
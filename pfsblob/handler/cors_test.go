@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	buck, _ := newTestBucket(t)
+	_, handler, err := BucketRouteAndHandlerWithOptions(buck, &HandlerOptions{
+		AllowUpload: true,
+		Authorizer:  BearerToken("token"),
+		CORS: &CORSOptions{
+			AllowedOrigins:   []string{"https://app.example.com"},
+			ExposedHeaders:   []string{"ETag", "Location"},
+			MaxAge:           time.Hour,
+			AllowCredentials: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandlerWithOptions(), got: %v \n", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		header map[string]string
+		status int
+		want   map[string]string
+	}{
+		{name: "preflight", method: http.MethodOptions, header: map[string]string{
+			"Origin":                         "https://app.example.com",
+			"Access-Control-Request-Method":  http.MethodPut,
+			"Access-Control-Request-Headers": "authorization, content-type",
+		}, status: http.StatusNoContent, want: map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Methods":     "GET, HEAD, PUT, POST",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Max-Age":           "3600",
+		}},
+		{name: "preflight origin", method: http.MethodOptions, header: map[string]string{
+			"Origin":                        "https://evil.example.com",
+			"Access-Control-Request-Method": http.MethodGet,
+		}, status: http.StatusForbidden, want: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "preflight method", method: http.MethodOptions, header: map[string]string{
+			"Origin":                        "https://app.example.com",
+			"Access-Control-Request-Method": http.MethodDelete,
+		}, status: http.StatusForbidden, want: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "preflight header", method: http.MethodOptions, header: map[string]string{
+			"Origin":                         "https://app.example.com",
+			"Access-Control-Request-Method":  http.MethodGet,
+			"Access-Control-Request-Headers": "X-Custom",
+		}, status: http.StatusForbidden, want: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "get", method: http.MethodGet, header: map[string]string{
+			"Origin":        "https://app.example.com",
+			"Authorization": "Bearer token",
+		}, status: http.StatusOK, want: map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Expose-Headers":    "ETag, Location",
+			"Vary":                             "Origin",
+		}},
+		{name: "get unauthorized", method: http.MethodGet, header: map[string]string{
+			"Origin": "https://app.example.com",
+		}, status: http.StatusUnauthorized, want: map[string]string{
+			"Access-Control-Allow-Origin": "https://app.example.com",
+		}},
+		{name: "get origin", method: http.MethodGet, header: map[string]string{
+			"Origin":        "https://evil.example.com",
+			"Authorization": "Bearer token",
+		}, status: http.StatusOK, want: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "get same origin", method: http.MethodGet, header: map[string]string{
+			"Authorization": "Bearer token",
+		}, status: http.StatusOK, want: map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"}},
+	}
+	for _, tt := range tests {
+		rec := serve(t, handler, tt.method, "/srv/dir/one.txt", tt.header)
+		if rec.Code != tt.status {
+			t.Errorf("%v: %v, got: %v want: %v \n", tt.name, tt.method, rec.Code, tt.status)
+		}
+		for k, v := range tt.want {
+			if got := rec.Header().Get(k); got != v {
+				t.Errorf("%v: %v, %v got: %v want: %v \n", tt.name, tt.method, k, got, v)
+			}
+		}
+	}
+
+	_, handler, _ = BucketRouteAndHandlerWithOptions(buck, &HandlerOptions{CORS: &CORSOptions{AllowedOrigins: []string{"*"}}})
+	rec := serve(t, handler, http.MethodGet, "/srv/dir/one.txt", map[string]string{"Origin": "https://app.example.com"})
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("GET /srv/dir/one.txt, Access-Control-Allow-Origin got: %v want: * \n", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "" {
+		t.Errorf("GET /srv/dir/one.txt, Vary got: %v want: none \n", got)
+	}
+}
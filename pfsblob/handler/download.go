@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Shivam010/upload"
+)
+
+// downloadDisposition returns the Content-Disposition forcing the download of
+// the requested file, if asked using the download query parameter, as in the
+// links returned by Bucket.GetDownloadUrl. The filename query parameter sets
+// the name of the downloaded file, it is the base of the path otherwise.
+func downloadDisposition(r *http.Request) string {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || strings.HasSuffix(r.URL.Path, "/") {
+		return ""
+	}
+	q := r.URL.Query()
+	if download, err := strconv.ParseBool(q.Get("download")); err != nil || !download {
+		return ""
+	}
+	filename := q.Get("filename")
+	if filename == "" {
+		filename = path.Base(r.URL.Path)
+	}
+	return upload.AttachmentDisposition(filename)
+}
+
+// downloadWriter overrides the Content-Disposition of the successful
+// responses, including the one stored in the attributes of the file
+type downloadWriter struct {
+	http.ResponseWriter
+	disposition string
+	wroteHeader bool
+}
+
+func (d *downloadWriter) WriteHeader(code int) {
+	if !d.wroteHeader {
+		d.wroteHeader = true
+		if code < http.StatusMultipleChoices || code == http.StatusNotModified {
+			d.Header().Set("Content-Disposition", d.disposition)
+		}
+	}
+	d.ResponseWriter.WriteHeader(code)
+}
+
+func (d *downloadWriter) Write(p []byte) (int, error) {
+	if !d.wroteHeader {
+		d.WriteHeader(http.StatusOK)
+	}
+	return d.ResponseWriter.Write(p)
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Shivam010/upload"
+)
+
+func TestDownload(t *testing.T) {
+	buck, _ := newTestBucket(t)
+	opts := &upload.WriteOptions{ContentDisposition: "inline"}
+	if _, err := buck.Upload(context.Background(), "dir/two.txt", strings.NewReader("two.txt"), opts); err != nil {
+		t.Fatalf("Upload(dir/two.txt), got: %v \n", err)
+	}
+	link, err := buck.GetDownloadUrl("dir/one.txt", "réport.txt")
+	if err != nil {
+		t.Fatalf("GetDownloadUrl(dir/one.txt), got: %v \n", err)
+	}
+	u, _ := url.Parse(link)
+	_, handler, err := BucketRouteAndHandler(buck)
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandler(), got: %v \n", err)
+	}
+
+	tests := []struct {
+		method string
+		target string
+		status int
+		want   string
+	}{
+		{method: http.MethodGet, target: u.RequestURI(), status: http.StatusOK, want: upload.AttachmentDisposition("réport.txt")},
+		{method: http.MethodHead, target: "/srv/dir/one.txt?download=1", status: http.StatusOK, want: `attachment; filename="one.txt"`},
+		{method: http.MethodGet, target: "/srv/dir/one.txt?download=0", status: http.StatusOK},
+		{method: http.MethodGet, target: "/srv/dir/one.txt", status: http.StatusOK},
+		{method: http.MethodGet, target: "/srv/dir/two.txt", status: http.StatusOK, want: "inline"},
+		{method: http.MethodGet, target: "/srv/dir/two.txt?download=true&filename=../x.txt", status: http.StatusOK, want: `attachment; filename="x.txt"`},
+		{method: http.MethodGet, target: "/srv/dir/three.txt?download=1", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := serve(t, handler, tt.method, tt.target, nil)
+		if rec.Code != tt.status {
+			t.Errorf("%v %v, got: %v want: %v \n", tt.method, tt.target, rec.Code, tt.status)
+		}
+		if got := rec.Header().Get("Content-Disposition"); got != tt.want {
+			t.Errorf("%v %v, Content-Disposition got: %v want: %v \n", tt.method, tt.target, got, tt.want)
+		}
+	}
+}
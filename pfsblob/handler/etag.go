@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// etag returns the strong ETag derived from the MD5 hash of the content of
+// the file, or fallback if the hash is not available
+func etag(md5 []byte, fallback string) string {
+	if len(md5) == 0 {
+		return fallback
+	}
+	return `"` + hex.EncodeToString(md5) + `"`
+}
+
+// fileETag returns the ETag of the file without attributes, the same as the
+// one returned by the file system blob for it
+func fileETag(st os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", st.ModTime().UnixNano(), st.Size())
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shivam010/upload/internal/httpx"
+)
+
+// DefaultListingPageSize is the number of entries of a listing page, if
+// ListingOptions.PageSize is zero
+const DefaultListingPageSize = 100
+
+// ListingOptions configures the listing of the directories, requested with a
+// path ending with "/". The listing is served as JSON, or as HTML if the
+// client accepts it or the format=html query parameter is set, and is
+// paginated using the page (see Listing.Next) and limit query parameters,
+// reading only the page listed from the bucket.
+type ListingOptions struct {
+	// Prefixes of the directories allowed to be listed, relative to the route,
+	// e.g. "public/", the empty prefix allows every directory
+	Prefixes []string
+	// PageSize is the maximum number of entries of a page, it is
+	// DefaultListingPageSize if zero
+	PageSize int
+}
+
+// allows reports whether the directory prefix can be listed
+func (l *ListingOptions) allows(prefix string) bool {
+	for _, p := range l.Prefixes {
+		if strings.HasPrefix(prefix, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listing is the JSON listing of a directory
+type Listing struct {
+	// Prefix of the directory, relative to the route
+	Prefix string `json:"prefix"`
+	// Entries of the directory page
+	Entries []*ListingEntry `json:"entries"`
+	// Next is the page query parameter of the next page, it is empty for
+	// the last page. A page may list fewer entries than the page size.
+	Next string `json:"next,omitempty"`
+}
+
+// ListingEntry is a file or a sub-directory of the listing
+type ListingEntry struct {
+	// Name relative to the directory, the names of sub-directories end with "/"
+	Name string `json:"name"`
+	// Dir is true for the sub-directories
+	Dir bool `json:"dir,omitempty"`
+	// Size of the file in bytes
+	Size int64 `json:"size"`
+	// ModTime of the file
+	ModTime time.Time `json:"modTime,omitempty"`
+	// Link of the file or sub-directory, as returned by Bucket.GetUrl
+	Link string `json:"link"`
+}
+
+// serveListing serves the listing of the requested directory, if enabled in
+// the options. It returns false if the request is not for an allowed directory.
+func (s *server) serveListing(w http.ResponseWriter, r *http.Request) bool {
+	l := s.opts.Listing
+	if l == nil || !strings.HasSuffix(r.URL.Path, "/") {
+		return false
+	}
+	prefix := s.authName(r)
+	if !l.allows(prefix) {
+		return false
+	}
+	q := r.URL.Query()
+	size := l.PageSize
+	if size <= 0 {
+		size = DefaultListingPageSize
+	}
+	if limit, err := strconv.Atoi(q.Get("limit")); err == nil && limit > 0 && limit < size {
+		size = limit
+	}
+	var token []byte
+	if page := q.Get("page"); page != "" {
+		var err error
+		if token, err = base64.RawURLEncoding.DecodeString(page); err != nil {
+			http.Error(w, "invalid page", http.StatusBadRequest)
+			return true
+		}
+	}
+	// a single page of the directory is read from the bucket
+	objects, next, err := s.buck.ListPage(r.Context(), prefix, "/", token, size)
+	if err != nil {
+		httpx.BucketError(w, err)
+		return true
+	}
+
+	entries := []*ListingEntry{}
+	for _, obj := range objects {
+		if s.isInternal(obj.Key) {
+			continue
+		}
+		entry := &ListingEntry{Name: strings.TrimPrefix(obj.Key, prefix), Dir: obj.IsDir, Link: s.buck.GetUrl(obj.Key)}
+		if !obj.IsDir {
+			entry.Size, entry.ModTime = obj.Size, obj.ModTime
+		}
+		entries = append(entries, entry)
+	}
+	if prefix != "" && token == nil && next == nil && len(entries) == 0 {
+		http.NotFound(w, r)
+		return true
+	}
+	listing := &Listing{Prefix: prefix, Entries: entries}
+	if next != nil {
+		listing.Next = base64.RawURLEncoding.EncodeToString(next)
+	}
+
+	// listings change with the bucket, unlike the files
+	w.Header().Set("Cache-Control", "no-cache")
+	if q.Get("format") == "html" || q.Get("format") == "" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = listingTemplate.Execute(w, listing)
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(listing)
+	return true
+}
+
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"query": func(page string) string { return "?" + url.Values{"page": {page}}.Encode() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of /{{.Prefix}}</title></head>
+<body>
+<h1>Index of /{{.Prefix}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{range .Entries}}<tr><td><a href="{{.Link}}">{{.Name}}</a></td><td>{{if not .Dir}}{{.Size}}{{end}}</td><td>{{if not .Dir}}{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}{{end}}</td></tr>
+{{end}}</table>
+{{if .Next}}<a href="{{query .Next}}">Next</a>{{end}}
+</body>
+</html>
+`))
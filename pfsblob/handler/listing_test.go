@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestListing(t *testing.T) {
+	buck, _ := newTestBucket(t)
+	for _, name := range []string{"dir/two.txt", "dir/sub/three.txt", "dir/sub/four.txt", "private/five.txt"} {
+		if _, err := buck.WriteAll(context.Background(), name, []byte(name)); err != nil {
+			t.Fatalf("WriteAll(%v), got: %v \n", name, err)
+		}
+	}
+	_, handler, err := BucketRouteAndHandlerWithOptions(buck, &HandlerOptions{
+		Listing: &ListingOptions{Prefixes: []string{"dir/"}, PageSize: 2},
+	})
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandlerWithOptions(), got: %v \n", err)
+	}
+
+	tests := []struct {
+		target string
+		status int
+		names  []string
+		next   bool
+	}{
+		{target: "/srv/dir/", status: http.StatusOK, names: []string{"one.txt", "sub/"}, next: true},
+		{target: "/srv/dir/?limit=1", status: http.StatusOK, names: []string{"one.txt"}, next: true},
+		{target: "/srv/dir/sub/", status: http.StatusOK, names: []string{"four.txt", "three.txt"}},
+		{target: "/srv/dir/?page=%25", status: http.StatusBadRequest},
+		{target: "/srv/dir/none/", status: http.StatusNotFound},
+		{target: "/srv/private/", status: http.StatusNotFound},
+		{target: "/srv/", status: http.StatusNotFound},
+	}
+	next := ""
+	for _, tt := range tests {
+		rec := serve(t, handler, http.MethodGet, tt.target, nil)
+		if rec.Code != tt.status {
+			t.Errorf("GET %v, got: %v want: %v \n", tt.target, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		listing := &Listing{}
+		if err := json.NewDecoder(rec.Body).Decode(listing); err != nil {
+			t.Fatalf("GET %v, got: %v \n", tt.target, err)
+		}
+		if next == "" {
+			next = listing.Next
+		}
+		var names []string
+		for _, e := range listing.Entries {
+			names = append(names, e.Name)
+			if !e.Dir && e.Link != buck.GetUrl(listing.Prefix+e.Name) {
+				t.Errorf("GET %v, link got: %v want: %v \n", tt.target, e.Link, buck.GetUrl(listing.Prefix+e.Name))
+			}
+		}
+		if strings.Join(names, ",") != strings.Join(tt.names, ",") || (listing.Next != "") != tt.next {
+			t.Errorf("GET %v, got: %v (%v) want: %v (next: %v) \n", tt.target, names, listing.Next, tt.names, tt.next)
+		}
+	}
+
+	// the next page continues from the first one
+	rec := serve(t, handler, http.MethodGet, "/srv/dir/?"+url.Values{"page": {next}}.Encode(), nil)
+	listing := &Listing{}
+	if err := json.NewDecoder(rec.Body).Decode(listing); err != nil || len(listing.Entries) != 1 || listing.Entries[0].Name != "two.txt" || listing.Next != "" {
+		t.Errorf("GET /srv/dir/?page=%v, got: %v %+v, %v want: two.txt \n", next, rec.Code, listing, err)
+	}
+
+	rec = serve(t, handler, http.MethodGet, "/srv/dir/", map[string]string{"Accept": "text/html"})
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" || !strings.Contains(rec.Body.String(), `href="?`+url.Values{"page": {next}}.Encode()+`"`) {
+		t.Errorf("GET /srv/dir/ (html), got: %v %s \n", ct, rec.Body)
+	}
+	if rec := serve(t, handler, http.MethodGet, "/srv/dir/one.txt", nil); rec.Code != http.StatusOK || rec.Body.String() != "one.txt" {
+		t.Errorf("GET /srv/dir/one.txt, got: %v %s \n", rec.Code, rec.Body)
+	}
+}
+
+func TestListingRules(t *testing.T) {
+	buck, _ := newTestBucket(t)
+	for _, name := range []string{"public/one.txt", "private/salary.pdf"} {
+		if _, err := buck.WriteAll(context.Background(), name, []byte(name)); err != nil {
+			t.Fatalf("WriteAll(%v), got: %v \n", name, err)
+		}
+	}
+	tests := []struct {
+		rules  Rules
+		target string
+		status int
+	}{
+		{rules: Rules{{Prefix: "", Read: Allow}, {Prefix: "private/", Read: NewURLSigner([]byte("secret"))}}, target: "/srv/private/", status: http.StatusUnauthorized},
+		{rules: Rules{{Prefix: "", Read: Allow}, {Prefix: "private/", Read: NewURLSigner([]byte("secret"))}}, target: "/srv/public/", status: http.StatusOK},
+		{rules: Rules{{Prefix: "public/", Read: Allow}}, target: "/srv/public/", status: http.StatusOK},
+		{rules: Rules{{Prefix: "public/", Read: Allow}}, target: "/srv/private/", status: http.StatusForbidden},
+		{rules: Rules{{Prefix: "public/", Read: Allow}}, target: "/srv/", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		_, handler, err := BucketRouteAndHandlerWithOptions(buck, &HandlerOptions{
+			Authorizer: tt.rules,
+			Listing:    &ListingOptions{Prefixes: []string{""}},
+		})
+		if err != nil {
+			t.Fatalf("BucketRouteAndHandlerWithOptions(), got: %v \n", err)
+		}
+		if rec := serve(t, handler, http.MethodGet, tt.target, nil); rec.Code != tt.status || tt.status != http.StatusOK && strings.Contains(rec.Body.String(), "salary") {
+			t.Errorf("GET %v (%v), got: %v %s want: %v \n", tt.target, tt.rules, rec.Code, rec.Body, tt.status)
+		}
+	}
+}
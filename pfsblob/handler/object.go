@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Shivam010/upload"
+	"github.com/Shivam010/upload/internal/httpx"
+	"github.com/Shivam010/upload/pfsblob"
+	"gocloud.dev/blob"
+)
+
+// serveObject serves the file reading it from the bucket, with the headers
+// set from its attributes. Ranges are read only when required, so that HEAD
+// and not modified responses never open the content of the file.
+func (s *server) serveObject(w http.ResponseWriter, r *http.Request) {
+	name := s.name(r)
+	if name == "" || strings.HasSuffix(r.URL.Path, "/") || s.isInternal(name) {
+		http.NotFound(w, r)
+		return
+	}
+	attrs, err := s.buck.Attributes(r.Context(), name)
+	if err != nil {
+		httpx.BucketError(w, err)
+		return
+	}
+	s.serveContent(w, r, name, attrs)
+}
+
+// serveContent serves the file name having the attributes
+func (s *server) serveContent(w http.ResponseWriter, r *http.Request, name string, attrs *blob.Attributes) {
+	setAttributesHeaders(w.Header(), attrs)
+	content := &objectReader{ctx: r.Context(), buck: s.buck, name: name, attrs: attrs, size: attrs.Size}
+	defer content.Close()
+	http.ServeContent(w, r, name, attrs.ModTime, content)
+	if errors.Is(content.err, upload.ErrObjectChanged) {
+		// the headers are sent already: the response is aborted, rather than
+		// completed with the content of another version of the file
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// isInternal reports whether the file name is an internal file, i.e. a file
+// staged in the bucket (see upload.Bucket.StagingPrefix) or a sidecar or
+// temporary file of the file system blob
+func (s *server) isInternal(name string) bool {
+	staging := s.buck.StagingPrefix()
+	if name = strings.TrimPrefix(name, "/"); name+"/" == staging || strings.HasPrefix(name, staging) {
+		return true
+	}
+	switch s.buck.Provider() {
+	case upload.FileSystem, upload.ProxiedFileSystem:
+		return pfsblob.IsInternal(name)
+	}
+	return false
+}
+
+// serveDelete deletes the file from the bucket
+func (s *server) serveDelete(w http.ResponseWriter, r *http.Request) {
+	name := s.name(r)
+	if name == "" || s.isInternal(name) {
+		http.NotFound(w, r)
+		return
+	}
+	if !authorize(w, r, s.opts.DeleteAuthorizer, name) {
+		return
+	}
+	if err := s.buck.Delete(r.Context(), name); err != nil {
+		httpx.BucketError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setAttributesHeaders sets the response headers using the attributes of the file
+func setAttributesHeaders(h http.Header, attrs *blob.Attributes) {
+	for key, val := range map[string]string{
+		"Content-Type":        attrs.ContentType,
+		"Content-Disposition": attrs.ContentDisposition,
+		"Content-Encoding":    attrs.ContentEncoding,
+		"Content-Language":    attrs.ContentLanguage,
+		"Cache-Control":       attrs.CacheControl,
+		"ETag":                etag(attrs.MD5, attrs.ETag),
+	} {
+		if val != "" {
+			h.Set(key, val)
+		}
+	}
+	if !attrs.ModTime.IsZero() {
+		h.Set("Last-Modified", attrs.ModTime.UTC().Format(http.TimeFormat))
+	}
+}
+
+// objectReader reads the file from the bucket, implementing io.ReadSeeker
+// for http.ServeContent, the file is opened from the offset on the first Read,
+// pinned to the version of the file having the attributes served
+type objectReader struct {
+	ctx    context.Context
+	buck   *upload.Bucket
+	name   string
+	attrs  *blob.Attributes
+	size   int64
+	offset int64
+	r      io.ReadCloser
+	// err is the error opening the file
+	err error
+}
+
+func (o *objectReader) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.r == nil {
+		r, err := o.buck.PinnedRangeReader(o.ctx, o.name, o.attrs, o.offset, -1)
+		if err != nil {
+			o.err = err
+			return 0, err
+		}
+		o.r = r
+	}
+	n, err := o.r.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("handler: negative offset")
+	}
+	if offset != o.offset {
+		_ = o.Close()
+		o.offset = offset
+	}
+	return offset, nil
+}
+
+func (o *objectReader) Close() error {
+	if o.r == nil {
+		return nil
+	}
+	err := o.r.Close()
+	o.r = nil
+	return err
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/upload"
+)
+
+func TestHead(t *testing.T) {
+	buck, _ := newTestBucket(t)
+	_, handler, err := BucketRouteAndHandler(buck)
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandler(), got: %v \n", err)
+	}
+	attrs, err := buck.Attributes(context.Background(), "dir/one.txt")
+	if err != nil {
+		t.Fatalf("Attributes(dir/one.txt), got: %v \n", err)
+	}
+
+	rec := serve(t, handler, http.MethodHead, "/srv/dir/one.txt", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("HEAD /srv/dir/one.txt, got: %v want: %v \n", rec.Code, http.StatusOK)
+	}
+	for k, v := range map[string]string{
+		"Content-Length": "7",
+		"Content-Type":   "text/plain; charset=utf-8",
+		"ETag":           etag(attrs.MD5, attrs.ETag),
+	} {
+		if got := rec.Header().Get(k); got != v || v == "" {
+			t.Errorf("HEAD /srv/dir/one.txt, %v got: %v want: %v \n", k, got, v)
+		}
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("HEAD /srv/dir/one.txt, got body: %s \n", rec.Body)
+	}
+	for _, target := range []string{"/srv/dir/one.txt.attrs", "/srv/dir/", "/srv/dir/two.txt"} {
+		if rec := serve(t, handler, http.MethodHead, target, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("HEAD %v, got: %v want: %v \n", target, rec.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	buck, _ := newTestBucket(t)
+	_, handler, err := BucketRouteAndHandlerWithOptions(buck, &HandlerOptions{
+		DeleteAuthorizer: AuthorizerFunc(func(r *http.Request, name string) error {
+			if r.Header.Get("X-Delete") != name {
+				return errors.New("not allowed")
+			}
+			return nil
+		}),
+	})
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandlerWithOptions(), got: %v \n", err)
+	}
+
+	tests := []struct {
+		target string
+		header map[string]string
+		status int
+	}{
+		{target: "/srv/dir/one.txt", status: http.StatusForbidden},
+		{target: "/srv/dir/one.txt", header: map[string]string{"X-Delete": "dir/one.txt"}, status: http.StatusNoContent},
+		{target: "/srv/dir/one.txt", header: map[string]string{"X-Delete": "dir/one.txt"}, status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := serve(t, handler, http.MethodDelete, tt.target, tt.header); rec.Code != tt.status {
+			t.Errorf("DELETE %v, got: %v want: %v \n", tt.target, rec.Code, tt.status)
+		}
+	}
+
+	_, readOnly, _ := BucketRouteAndHandler(buck)
+	rec := serve(t, readOnly, http.MethodDelete, "/srv/dir/one.txt", nil)
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("DELETE /srv/dir/one.txt, got: %v (%v) want: %v \n", rec.Code, rec.Header().Get("Allow"), http.StatusMethodNotAllowed)
+	}
+}
+
+func TestNewBucketHandler(t *testing.T) {
+	buck := upload.NewBucket("mem://")
+	if _, err := buck.WriteAll(context.Background(), "dir/one.txt", []byte("0123456789")); err != nil {
+		t.Fatalf("WriteAll(dir/one.txt), got: %v \n", err)
+	}
+	attrs, err := buck.Attributes(context.Background(), "dir/one.txt")
+	if err != nil {
+		t.Fatalf("Attributes(dir/one.txt), got: %v \n", err)
+	}
+	reads := 0
+	buck.Use(func(next upload.Op) upload.Op {
+		return func(ctx context.Context, op *upload.Operation) error {
+			if op.Kind == upload.OpRead {
+				reads++
+			}
+			return next(ctx, op)
+		}
+	})
+	h, err := NewBucketHandler(buck, "/static/", nil)
+	if err != nil {
+		t.Fatalf("NewBucketHandler(), got: %v \n", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header map[string]string
+		status int
+		body   string
+		reads  int
+	}{
+		{name: "get", target: "/static/dir/one.txt", status: http.StatusOK, body: "0123456789", reads: 1},
+		{name: "head", method: http.MethodHead, target: "/static/dir/one.txt", status: http.StatusOK},
+		{name: "range", target: "/static/dir/one.txt", header: map[string]string{"Range": "bytes=2-4"}, status: http.StatusPartialContent, body: "234", reads: 1},
+		{name: "if-none-match", target: "/static/dir/one.txt", header: map[string]string{"If-None-Match": etag(attrs.MD5, attrs.ETag)}, status: http.StatusNotModified},
+		{name: "if-modified-since", target: "/static/dir/one.txt", header: map[string]string{
+			"If-Modified-Since": attrs.ModTime.Add(time.Second).UTC().Format(http.TimeFormat),
+		}, status: http.StatusNotModified},
+		{name: "missing", target: "/static/dir/two.txt", status: http.StatusNotFound},
+		{name: "directory", target: "/static/dir/", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		reads = 0
+		if tt.method == "" {
+			tt.method = http.MethodGet
+		}
+		rec := serve(t, h.ServeHTTP, tt.method, tt.target, tt.header)
+		if rec.Code != tt.status || rec.Body.String() != tt.body && tt.body != "" {
+			t.Errorf("%v: %v %v, got: %v %s want: %v %v \n", tt.name, tt.method, tt.target, rec.Code, rec.Body, tt.status, tt.body)
+		}
+		if reads != tt.reads {
+			t.Errorf("%v: reads got: %v want: %v \n", tt.name, reads, tt.reads)
+		}
+	}
+	rec := serve(t, h.ServeHTTP, http.MethodGet, "/static/dir/one.txt", nil)
+	for k, v := range map[string]string{
+		"Content-Type":              "text/plain; charset=utf-8",
+		"ETag":                      etag(attrs.MD5, attrs.ETag),
+		"Strict-Transport-Security": "max-age=31536000",
+	} {
+		if got := rec.Header().Get(k); got != v {
+			t.Errorf("GET /static/dir/one.txt, %v got: %v want: %v \n", k, got, v)
+		}
+	}
+}
+
+func TestObjectChanged(t *testing.T) {
+	buck := upload.NewBucket("mem://")
+	if _, err := buck.WriteAll(context.Background(), "one.txt", []byte("0123456789")); err != nil {
+		t.Fatalf("WriteAll(one.txt), got: %v \n", err)
+	}
+	// the file is overwritten once its attributes are read
+	overwrite := true
+	buck.Use(func(next upload.Op) upload.Op {
+		return func(ctx context.Context, op *upload.Operation) error {
+			if op.Kind == upload.OpRead && overwrite {
+				overwrite = false
+				if _, err := buck.WriteAll(ctx, "one.txt", []byte("new")); err != nil {
+					return err
+				}
+			}
+			return next(ctx, op)
+		}
+	})
+	h, err := NewBucketHandler(buck, "/static/", nil)
+	if err != nil {
+		t.Fatalf("NewBucketHandler(), got: %v \n", err)
+	}
+	defer func() {
+		if got := recover(); got != http.ErrAbortHandler {
+			t.Errorf("GET /static/one.txt, got: %v want: %v \n", got, http.ErrAbortHandler)
+		}
+	}()
+	rec := serve(t, h.ServeHTTP, http.MethodGet, "/static/one.txt", nil)
+	t.Errorf("GET /static/one.txt, got: %v %s want: aborted \n", rec.Code, rec.Body)
+}
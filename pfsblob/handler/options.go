@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// CachePolicy sets the Cache-Control header of the files matching its
+// Prefix and Extension, an empty Prefix or Extension matches every file
+type CachePolicy struct {
+	// Prefix of the file names, relative to the route, e.g. "static/"
+	Prefix string
+	// Extension of the file names, e.g. ".js"
+	Extension string
+	// CacheControl is the value of the Cache-Control header, e.g. "no-cache"
+	CacheControl string
+}
+
+func (p CachePolicy) matches(name string) bool {
+	return strings.HasPrefix(name, p.Prefix) && (p.Extension == "" || path.Ext(name) == p.Extension)
+}
+
+// HandlerOptions configures the headers and caching policy of the handler
+type HandlerOptions struct {
+	// CacheControl is the default value of the Cache-Control header, it is not
+	// set if empty. It is only set on the successful (and not modified)
+	// responses to GET and HEAD requests, never on the errors.
+	CacheControl string
+	// CachePolicies override the CacheControl for the matching files, the first
+	// matching policy is used. The Cache-Control stored in the attributes of a
+	// file takes precedence over both.
+	CachePolicies []CachePolicy
+	// ContentSecurityPolicy is the value of the Content-Security-Policy header,
+	// it is not set if empty
+	ContentSecurityPolicy string
+	// DisableHSTS disables the Strict-Transport-Security header, which is never
+	// set for the Proxied File System buckets without the secure query parameter
+	DisableHSTS bool
+	// Header sets additional headers or overrides the default security
+	// headers, a header with no values is removed
+	Header http.Header
+
+	// Authorizer authorises every request, e.g. using BearerToken, URLSigner
+	// or per-prefix Rules, all requests are allowed if nil. The files served
+	// to the authorised requests are only cached privately, the Cache-Control
+	// being made "private" and varying with the Authorization header.
+	Authorizer Authorizer
+
+	// AllowUpload enables writing the files into the bucket, using PUT
+	// requests with the content as body, or multipart/form-data POST requests
+	// with the content as the first file part (stored by its own file name if
+	// the request path ends with "/"). The JSON response contains the name and
+	// link of the file.
+	AllowUpload bool
+	// MaxUploadSize is the maximum size in bytes of an uploaded file, there
+	// is no limit if zero
+	MaxUploadSize int64
+	// AllowedContentTypes restricts the content types of the uploaded files,
+	// e.g. "image/png" or "image/*", every content type is allowed if empty
+	AllowedContentTypes []string
+
+	// DeleteAuthorizer enables deleting the files from the bucket using DELETE
+	// requests, which are only served if authorised by it (and the Authorizer)
+	DeleteAuthorizer Authorizer
+
+	// Precompressed serves the ".br" and ".gz" variants written alongside the
+	// files, e.g. "app.js.br" for "app.js", to the clients accepting them
+	Precompressed bool
+	// Compress gzip-compresses the files of the CompressibleTypes on the fly,
+	// for the clients accepting it, unless they are already encoded
+	Compress bool
+	// CompressMinSize is the minimum size in bytes of the files compressed
+	// on the fly, smaller files are served as they are
+	CompressMinSize int64
+	// CompressibleTypes are the content types compressed on the fly, e.g.
+	// "text/*", the DefaultCompressibleTypes are used if empty
+	CompressibleTypes []string
+
+	// CORS enables the cross-origin requests, answering the OPTIONS preflight
+	// requests and adding the CORS headers to the responses, if not nil
+	CORS *CORSOptions
+
+	// Listing enables the listing of the directories, if not nil, they are
+	// not found otherwise
+	Listing *ListingOptions
+}
+
+// DefaultHandlerOptions returns the options used by BucketRouteAndHandler
+func DefaultHandlerOptions() *HandlerOptions {
+	return &HandlerOptions{
+		CacheControl: "public, max-age=31536000, immutable",
+	}
+}
+
+// cacheControl returns the value of the Cache-Control header for the file name
+func (o *HandlerOptions) cacheControl(name string) string {
+	for _, p := range o.CachePolicies {
+		if p.matches(name) {
+			return p.CacheControl
+		}
+	}
+	return o.CacheControl
+}
+
+// setHeaders sets the security headers, the caching ones are set by the
+// cacheWriter once the status of the response is known
+func (o *HandlerOptions) setHeaders(h http.Header, secure bool) {
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "strict-origin")
+	h.Set("X-XSS-Protection", "1; mode=block")
+	h.Set("X-Frame-Options", "SAMEORIGIN")
+	if secure && !o.DisableHSTS {
+		h.Set("Strict-Transport-Security", "max-age=31536000")
+	}
+	if o.ContentSecurityPolicy != "" {
+		h.Set("Content-Security-Policy", o.ContentSecurityPolicy)
+	}
+	if o.Precompressed || o.Compress {
+		h.Set("Vary", "Accept-Encoding")
+	}
+	for key, values := range o.Header {
+		h.Del(key)
+		for _, v := range values {
+			h.Add(key, v)
+		}
+	}
+}
+
+// cacheWriter sets the Cache-Control header of the file served once the
+// status of the response is known: the cache policy is only for the files
+// served and the not modified responses, the errors are never cached along
+type cacheWriter struct {
+	http.ResponseWriter
+	// cacheControl is the value of the Cache-Control header, unless the
+	// response already has one, e.g. from the attributes of the file
+	cacheControl string
+	// private restricts the caching to the client, for the authorised requests
+	private     bool
+	wroteHeader bool
+}
+
+func (c *cacheWriter) WriteHeader(code int) {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		h := c.Header()
+		if code >= 200 && code < 300 || code == http.StatusNotModified {
+			cc := h.Get("Cache-Control")
+			if cc == "" {
+				cc = c.cacheControl
+			}
+			if c.private {
+				cc = privateCacheControl(cc)
+				h.Add("Vary", "Authorization")
+			}
+			if cc != "" {
+				h.Set("Cache-Control", cc)
+			}
+		} else {
+			h.Del("Cache-Control")
+		}
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *cacheWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// privateCacheControl returns the Cache-Control cc made private, so that the
+// shared caches never serve the response to other clients
+func privateCacheControl(cc string) string {
+	directives := []string{"private"}
+	for _, d := range strings.Split(cc, ",") {
+		switch d = strings.TrimSpace(d); strings.ToLower(d) {
+		case "", "public", "private":
+		default:
+			directives = append(directives, d)
+		}
+	}
+	return strings.Join(directives, ", ")
+}
+
+// matchContentType reports whether the media type of contentType matches any
+// of the patterns, e.g. "image/png" or "image/*"
+func matchContentType(patterns []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, p := range patterns {
+		if p == mediaType || strings.HasSuffix(p, "/*") && strings.HasPrefix(mediaType, p[:len(p)-1]) {
+			return true
+		}
+	}
+	return false
+}
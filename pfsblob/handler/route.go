@@ -3,51 +3,270 @@ package handler
 import (
 	"errors"
 	"github.com/Shivam010/upload"
+	"github.com/Shivam010/upload/internal/httpx"
+	"github.com/Shivam010/upload/pfsblob"
 	"net/http"
 	"os"
+	"path"
+	"strings"
+	"time"
 )
 
+// BucketRouteAndHandler returns the route and the handler serving the files of
+// the Proxied File System bucket, using the DefaultHandlerOptions
 func BucketRouteAndHandler(buck *upload.Bucket) (route string, handler func(http.ResponseWriter, *http.Request), err error) {
+	return BucketRouteAndHandlerWithOptions(buck, nil)
+}
+
+// BucketRouteAndHandlerWithOptions returns the route and the handler serving the
+// files of the Proxied File System bucket, configured using the provided options,
+// if opts is nil the DefaultHandlerOptions are used
+func BucketRouteAndHandlerWithOptions(buck *upload.Bucket, opts *HandlerOptions) (route string, handler func(http.ResponseWriter, *http.Request), err error) {
 	if buck == nil || buck.Provider() != upload.ProxiedFileSystem {
 		return "", nil, errors.New("handler: can only work on Proxied File System Bucket provider")
 	}
+	if opts == nil {
+		opts = DefaultHandlerOptions()
+	}
 	// Bucket region is alias for the route
 	// Bucket account is alias for the storage directory
-	route = "/" + buck.GetMetadata("route")
-	handlerFunc := http.StripPrefix(
-		route,
-		http.FileServer(wrappedFileSystem{
-			fs: http.Dir(buck.GetMetadata("storage")),
-		}),
-	).ServeHTTP
-
-	return route + "/",
-		func(w http.ResponseWriter, r *http.Request) {
-			// security and caching headers
-			w.Header().Set("X-Content-Type-Options", "nosniff")
-			w.Header().Set("Referrer-Policy", "strict-origin")
-			w.Header().Set("X-XSS-Protection", "1; mode=block")
-			w.Header().Set("X-Frame-Options", "SAMEORIGIN")
-			w.Header().Set("Strict-Transport-Security", "max-age=31536000")
-			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-			handlerFunc(w, r)
-		},
-		nil
+	route = buck.GetMetadata("route")
+	if route != "" {
+		route = "/" + route
+	}
+	s := &server{
+		buck:   buck,
+		opts:   opts,
+		route:  route,
+		secure: buck.GetMetadata("secure") == "true",
+		fs:     http.Dir(buck.GetMetadata("storage")),
+	}
+	return s.route + "/", s.ServeHTTP, nil
+}
+
+// NewBucketHandler returns the handler serving the files of any bucket (in-memory,
+// file system, cloud, etc.) under the route, reading them using the bucket itself,
+// with support for Range and conditional requests. It should be registered for
+// the pattern route + "/", if opts is nil the DefaultHandlerOptions are used.
+// The Strict-Transport-Security header is set unless disabled in the options.
+func NewBucketHandler(buck *upload.Bucket, route string, opts *HandlerOptions) (http.Handler, error) {
+	if buck == nil {
+		return nil, errors.New("handler: bucket is required")
+	}
+	if opts == nil {
+		opts = DefaultHandlerOptions()
+	}
+	route = strings.Trim(route, "/")
+	if route != "" {
+		route = "/" + route
+	}
+	return &server{
+		buck:   buck,
+		opts:   opts,
+		route:  route,
+		secure: true,
+	}, nil
+}
+
+// server serves the files of the bucket under the route
+type server struct {
+	buck   *upload.Bucket
+	opts   *HandlerOptions
+	route  string
+	secure bool
+	// fs serves the files of the Proxied File System bucket directly, for
+	// other buckets it is nil and files are read using the bucket
+	fs http.FileSystem
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// security and caching headers, the cache policy of the files is only
+	// for the reads
+	s.opts.setHeaders(w.Header(), s.secure)
+	cache := &cacheWriter{ResponseWriter: w}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		cache.cacheControl = s.opts.cacheControl(s.name(r))
+	}
+	cache.private = s.opts.Authorizer != nil
+	w = cache
+
+	rec := httpx.NewResponseRecorder(w)
+	start := time.Now()
+	defer func() { logRequest(s.buck.Logger(), r, rec, time.Since(start)) }()
+
+	// preflight requests carry no credentials, so are answered before authorising
+	if s.serveCORS(rec, r) {
+		return
+	}
+	// the name of POST requests is known once the form is read, see serveUpload
+	if r.Method != http.MethodPost && !authorize(rec, r, s.opts.Authorizer, s.authName(r)) {
+		return
+	}
+	var rw http.ResponseWriter = rec
+	if download := downloadDisposition(r); download != "" {
+		rw = &downloadWriter{ResponseWriter: rw, disposition: download}
+	}
+	if s.opts.Compress && r.Method == http.MethodGet && acceptsEncoding(r, "gzip") {
+		cw := &compressWriter{ResponseWriter: rw, opts: s.opts}
+		defer cw.Close()
+		rw = cw
+	}
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		if !s.opts.AllowUpload {
+			s.methodNotAllowed(rec)
+			break
+		}
+		s.serveUpload(rec, r)
+	case http.MethodDelete:
+		if s.opts.DeleteAuthorizer == nil {
+			s.methodNotAllowed(rec)
+			break
+		}
+		s.serveDelete(rec, r)
+	case http.MethodHead:
+		if !s.serveListing(rw, r) && !s.servePrecompressed(rw, r) {
+			s.serveObject(rw, r)
+		}
+	default:
+		switch {
+		case s.serveListing(rw, r):
+		case s.servePrecompressed(rw, r):
+		case s.fs == nil:
+			s.serveObject(rw, r)
+		default:
+			http.StripPrefix(
+				s.route,
+				http.FileServer(wrappedFileSystem{fs: s.fs, header: w.Header(), internal: s.isInternal}),
+			).ServeHTTP(rw, r)
+		}
+	}
+}
+
+// methodNotAllowed responds with the methods allowed by the options
+func (s *server) methodNotAllowed(w http.ResponseWriter) {
+	w.Header().Set("Allow", strings.Join(s.allowedMethods(), ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// allowedMethods returns the methods served, as enabled by the options
+func (s *server) allowedMethods() []string {
+	methods := []string{http.MethodGet, http.MethodHead}
+	if s.opts.AllowUpload {
+		methods = append(methods, http.MethodPut, http.MethodPost)
+	}
+	if s.opts.DeleteAuthorizer != nil {
+		methods = append(methods, http.MethodDelete)
+	}
+	return methods
 }
 
+// name returns the name of the requested file, relative to the route
+func (s *server) name(r *http.Request) string {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), s.route)
+	return strings.TrimPrefix(name, "/")
+}
+
+// authName returns the name the request is authorised for: the directory
+// prefix (ending with "/") for the directory requests, e.g. the listings, so
+// that they match the prefix rules of the files listed, and the file name otherwise
+func (s *server) authName(r *http.Request) string {
+	name := s.name(r)
+	if name != "" && strings.HasSuffix(r.URL.Path, "/") {
+		name += "/"
+	}
+	return name
+}
+
+// wrappedFileSystem serves only the regular files, refusing directories and
+// the internal (staged, sidecar and temporary) files, and sets the headers
+// stored in the sidecar attributes of the file being served
 type wrappedFileSystem struct {
 	fs http.FileSystem
+	// header of the response
+	header http.Header
+	// internal reports whether the file at path is internal
+	internal func(path string) bool
 }
 
 func (w wrappedFileSystem) Open(path string) (http.File, error) {
+	if w.internal(path) {
+		return nil, os.ErrNotExist
+	}
 	file, err := w.fs.Open(path)
 	if err != nil {
 		return nil, err
 	}
 
 	st, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
 	if st.IsDir() {
+		_ = file.Close()
 		return nil, os.ErrNotExist
 	}
+	if err := w.setAttributeHeaders(path, st); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
 	return file, nil
 }
+
+// setAttributeHeaders sets the response headers using the sidecar attributes
+// of the file at path, if there are any, and its ETag, which lets http.FileServer
+// honour the If-None-Match, If-Match and If-Range requests
+func (w wrappedFileSystem) setAttributeHeaders(path string, st os.FileInfo) error {
+	sidecar, err := w.fs.Open(path + pfsblob.AttrsExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			w.header.Set("ETag", fileETag(st))
+			return nil
+		}
+		return err
+	}
+	defer sidecar.Close()
+	attrs, err := pfsblob.DecodeAttributes(sidecar)
+	if err != nil {
+		return err
+	}
+	// the hash of a sidecar of another write is not of the content served
+	if sst, err := sidecar.Stat(); err != nil || !pfsblob.IsCurrent(st, sst) {
+		attrs.MD5 = nil
+	}
+	for key, val := range map[string]string{
+		"Content-Type":        attrs.ContentType,
+		"Content-Disposition": attrs.ContentDisposition,
+		"Content-Encoding":    attrs.ContentEncoding,
+		"Content-Language":    attrs.ContentLanguage,
+		"Cache-Control":       attrs.CacheControl,
+		"ETag":                etag(attrs.MD5, fileETag(st)),
+	} {
+		if val != "" {
+			w.header.Set(key, val)
+		}
+	}
+	return nil
+}
+
+// logRequest logs the served request using the logger of the bucket, server
+// errors are logged at upload.LevelError, client errors at upload.LevelWarn
+// and the rest at upload.LevelInfo
+func logRequest(logger upload.Logger, r *http.Request, rec *httpx.ResponseRecorder, d time.Duration) {
+	level := upload.LevelInfo
+	switch {
+	case rec.Status >= http.StatusInternalServerError:
+		level = upload.LevelError
+	case rec.Status >= http.StatusBadRequest:
+		level = upload.LevelWarn
+	}
+	logger.Log(r.Context(), level, "handler: request served",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"remote", r.RemoteAddr,
+		"status", rec.Status,
+		"size", rec.Written,
+		"duration", d,
+	)
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shivam010/upload"
+)
+
+func newTestBucket(t *testing.T) (*upload.Bucket, string) {
+	t.Helper()
+	dir := t.TempDir()
+	buck := upload.NewBucket("pfs://localhost" + dir + "?route=srv")
+	if _, err := buck.WriteAll(context.Background(), "dir/one.txt", []byte("one.txt")); err != nil {
+		t.Fatalf("WriteAll(dir/one.txt), got: %v \n", err)
+	}
+	return buck, dir
+}
+
+func serve(t *testing.T, handler http.HandlerFunc, method, target string, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestBucketRouteAndHandler(t *testing.T) {
+	buck, dir := newTestBucket(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "dir", "two.bin"), []byte("two"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	sidecar := `{"user.cache_control":"no-cache","user.content_disposition":"attachment","user.content_encoding":"","user.content_type":"application/x-two"}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "dir", "two.bin.attrs"), []byte(sidecar), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "dir", "fileblob123"), []byte("temp"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	route, handler, err := BucketRouteAndHandler(buck)
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandler(), got: %v \n", err)
+	}
+	if route != "/srv/" {
+		t.Errorf("Route, got: %v want: /srv/ \n", route)
+	}
+
+	tests := []struct {
+		target string
+		status int
+		header map[string]string
+	}{
+		{target: "/srv/dir/one.txt", status: http.StatusOK, header: map[string]string{
+			"Content-Type":  "text/plain; charset=utf-8",
+			"Cache-Control": "public, max-age=31536000, immutable",
+		}},
+		{target: "/srv/dir/two.bin", status: http.StatusOK, header: map[string]string{
+			"Content-Type":        "application/x-two",
+			"Content-Disposition": "attachment",
+			"Cache-Control":       "no-cache",
+		}},
+		{target: "/srv/dir/", status: http.StatusNotFound},
+		{target: "/srv/dir/one.txt.attrs", status: http.StatusNotFound},
+		{target: "/srv/dir/fileblob123", status: http.StatusNotFound},
+		// the errors are not cached along with the files
+		{target: "/srv/dir/three.txt", status: http.StatusNotFound, header: map[string]string{
+			"Cache-Control": "",
+		}},
+	}
+	for _, tt := range tests {
+		rec := serve(t, handler, http.MethodGet, tt.target, nil)
+		if rec.Code != tt.status {
+			t.Errorf("GET %v, got: %v want: %v \n", tt.target, rec.Code, tt.status)
+		}
+		for k, v := range tt.header {
+			if got := rec.Header().Get(k); got != v {
+				t.Errorf("GET %v, %v got: %v want: %v \n", tt.target, k, got, v)
+			}
+		}
+	}
+}
+
+func TestBucketRouteAndHandlerWithOptions(t *testing.T) {
+	buck, _ := newTestBucket(t)
+	if _, err := buck.WriteAll(context.Background(), "static/app.js", []byte("app.js")); err != nil {
+		t.Fatalf("WriteAll(static/app.js), got: %v \n", err)
+	}
+	opts := &HandlerOptions{
+		CacheControl: "no-cache",
+		CachePolicies: []CachePolicy{
+			{Prefix: "static/", Extension: ".js", CacheControl: "public, max-age=600"},
+		},
+		ContentSecurityPolicy: "default-src 'none'",
+		Header:                http.Header{"X-Frame-Options": nil, "X-Served-By": {"pfs"}},
+	}
+	_, handler, err := BucketRouteAndHandlerWithOptions(buck, opts)
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandlerWithOptions(), got: %v \n", err)
+	}
+
+	tests := []struct {
+		target string
+		header map[string]string
+	}{
+		{target: "/srv/dir/one.txt", header: map[string]string{
+			"Cache-Control":             "no-cache",
+			"Content-Security-Policy":   "default-src 'none'",
+			"X-Served-By":               "pfs",
+			"X-Frame-Options":           "",
+			"Strict-Transport-Security": "",
+		}},
+		{target: "/srv/static/app.js", header: map[string]string{
+			"Cache-Control": "public, max-age=600",
+		}},
+	}
+	for _, tt := range tests {
+		rec := serve(t, handler, http.MethodGet, tt.target, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %v, got: %v want: %v \n", tt.target, rec.Code, http.StatusOK)
+		}
+		for k, v := range tt.header {
+			if got := rec.Header().Get(k); got != v {
+				t.Errorf("GET %v, %v got: %v want: %v \n", tt.target, k, got, v)
+			}
+		}
+	}
+
+	secure := upload.NewBucket("pfs://localhost" + t.TempDir() + "?secure=true")
+	route, handler, err := BucketRouteAndHandler(secure)
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandler(), got: %v \n", err)
+	}
+	if route != "/" {
+		t.Errorf("Route, got: %v want: / \n", route)
+	}
+	rec := serve(t, handler, http.MethodGet, "/missing", nil)
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=31536000" {
+		t.Errorf("GET /missing, Strict-Transport-Security got: %v want: max-age=31536000 \n", got)
+	}
+}
+
+func TestStagedFiles(t *testing.T) {
+	ctx := context.Background()
+	pfsBuck, _ := newTestBucket(t)
+	memBuck := upload.NewBucket("mem://")
+	_, pfsHandler, err := BucketRouteAndHandlerWithOptions(pfsBuck, &HandlerOptions{Listing: &ListingOptions{Prefixes: []string{""}}})
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandlerWithOptions(), got: %v \n", err)
+	}
+	memHandler, err := NewBucketHandler(memBuck, "/srv/", &HandlerOptions{Listing: &ListingOptions{Prefixes: []string{""}}})
+	if err != nil {
+		t.Fatalf("NewBucketHandler(), got: %v \n", err)
+	}
+
+	for buck, handler := range map[*upload.Bucket]http.HandlerFunc{pfsBuck: pfsHandler, memBuck: memHandler.ServeHTTP} {
+		id, err := buck.NewResumableUpload(ctx, "big.bin")
+		if err != nil {
+			t.Fatalf("NewResumableUpload(), got: %v \n", err)
+		}
+		if err := buck.UploadPart(ctx, id, 1, strings.NewReader("secret part")); err != nil {
+			t.Fatalf("UploadPart(), got: %v \n", err)
+		}
+		staging := "/srv/" + buck.StagingPrefix()
+		for _, target := range []string{staging + id + "/part-00001", staging + id + "/upload.json", staging, staging + id + "/"} {
+			for _, method := range []string{http.MethodGet, http.MethodHead} {
+				if rec := serve(t, handler, method, target, nil); rec.Code != http.StatusNotFound {
+					t.Errorf("%v %v (%v), got: %v want: %v \n", method, target, buck, rec.Code, http.StatusNotFound)
+				}
+			}
+		}
+		if rec := serve(t, handler, http.MethodGet, "/srv/", nil); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), id) || strings.Contains(rec.Body.String(), buck.StagingPrefix()) {
+			t.Errorf("GET /srv/ (%v), got: %v %s want: no staged files \n", buck, rec.Code, rec.Body)
+		}
+	}
+}
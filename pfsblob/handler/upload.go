@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Shivam010/upload"
+)
+
+// errTooLarge is returned when the uploaded file exceeds the MaxUploadSize
+var errTooLarge = errors.New("handler: uploaded file is too large")
+
+// uploadResponse is the JSON response of a successful upload
+type uploadResponse struct {
+	Name string `json:"name"`
+	Link string `json:"link"`
+}
+
+// serveUpload writes the file of a PUT request or the first file part of a
+// multipart/form-data POST request into the bucket
+func (s *server) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if s.opts.MaxUploadSize > 0 && r.Method == http.MethodPut && r.ContentLength > s.opts.MaxUploadSize {
+		http.Error(w, errTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	name, isDir := s.name(r), strings.HasSuffix(r.URL.Path, "/")
+	body, contentType := io.Reader(r.Body), r.Header.Get("Content-Type")
+	if r.Method == http.MethodPost {
+		part, err := filePart(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer part.Close()
+		// a directory target stores the file by its own name
+		if base := path.Base(part.FileName()); name == "" || isDir {
+			if base == "." || base == ".." || base == "/" {
+				http.Error(w, "handler: invalid file name", http.StatusBadRequest)
+				return
+			}
+			name, isDir = path.Join(name, base), false
+		}
+		body, contentType = part, part.Header.Get("Content-Type")
+	}
+	if name == "" || isDir || s.isInternal(name) {
+		http.Error(w, "handler: invalid file name", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPost && !authorize(w, r, s.opts.Authorizer, name) {
+		return
+	}
+	if s.opts.MaxUploadSize > 0 {
+		body = &limitedReader{r: body, n: s.opts.MaxUploadSize}
+	}
+
+	// detect the content type, if not provided, to check it is allowed
+	br := bufio.NewReaderSize(body, 512)
+	if contentType == "" || contentType == "application/octet-stream" {
+		sniff, _ := br.Peek(512)
+		contentType = http.DetectContentType(sniff)
+	}
+	if !s.opts.allowsContentType(contentType) {
+		http.Error(w, "handler: content type "+contentType+" is not allowed", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	link, err := s.buck.Upload(r.Context(), name, br, &upload.WriteOptions{ContentType: contentType})
+	if err != nil {
+		if errors.Is(err, errTooLarge) {
+			http.Error(w, errTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "handler: upload failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", link)
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(uploadResponse{Name: name, Link: link})
+}
+
+// filePart returns the first file part of the multipart/form-data request
+func filePart(r *http.Request) (*multipart.Part, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("handler: no file in the multipart form")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() != "" {
+			return part, nil
+		}
+		_ = part.Close()
+	}
+}
+
+// allowsContentType reports whether the content type is allowed for uploads
+func (o *HandlerOptions) allowsContentType(contentType string) bool {
+	return len(o.AllowedContentTypes) == 0 || matchContentType(o.AllowedContentTypes, contentType)
+}
+
+// limitedReader fails with errTooLarge after n bytes
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return 0, errTooLarge
+	}
+	return n, err
+}
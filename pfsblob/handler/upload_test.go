@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpload(t *testing.T) {
+	ctx := context.Background()
+	buck, _ := newTestBucket(t)
+	_, handler, err := BucketRouteAndHandlerWithOptions(buck, &HandlerOptions{
+		AllowUpload:         true,
+		MaxUploadSize:       16,
+		AllowedContentTypes: []string{"text/*", "image/png"},
+	})
+	if err != nil {
+		t.Fatalf("BucketRouteAndHandlerWithOptions(), got: %v \n", err)
+	}
+
+	multipartBody := func(filename, content string) (string, *bytes.Buffer) {
+		buf := &bytes.Buffer{}
+		mw := multipart.NewWriter(buf)
+		_ = mw.WriteField("description", "ignored")
+		fw, _ := mw.CreateFormFile("file", filename)
+		_, _ = fw.Write([]byte(content))
+		_ = mw.Close()
+		return mw.FormDataContentType(), buf
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        func() (string, *bytes.Buffer)
+		status      int
+		file        string
+	}{
+		{
+			name: "put", method: http.MethodPut, target: "/srv/put/one.txt",
+			body:   func() (string, *bytes.Buffer) { return "", bytes.NewBufferString("put one.txt") },
+			status: http.StatusCreated, file: "put/one.txt",
+		},
+		{
+			name: "post-dir", method: http.MethodPost, target: "/srv/post/",
+			body:   func() (string, *bytes.Buffer) { return multipartBody("../two.txt", "post two.txt") },
+			status: http.StatusCreated, file: "post/two.txt",
+		},
+		{
+			name: "post-name", method: http.MethodPost, target: "/srv/post/three.txt",
+			body:   func() (string, *bytes.Buffer) { return multipartBody("other.txt", "post three.txt") },
+			status: http.StatusCreated, file: "post/three.txt",
+		},
+		{
+			name: "too-large", method: http.MethodPut, target: "/srv/large.txt",
+			body:   func() (string, *bytes.Buffer) { return "", bytes.NewBufferString(strings.Repeat("large", 10)) },
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "too-large-multipart", method: http.MethodPost, target: "/srv/",
+			body:   func() (string, *bytes.Buffer) { return multipartBody("large.txt", strings.Repeat("large", 10)) },
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "content-type", method: http.MethodPut, target: "/srv/data.json",
+			body:   func() (string, *bytes.Buffer) { return "application/json", bytes.NewBufferString("{}") },
+			status: http.StatusUnsupportedMediaType,
+		},
+		{
+			name: "sidecar", method: http.MethodPut, target: "/srv/dir/one.txt.attrs",
+			body:   func() (string, *bytes.Buffer) { return "", bytes.NewBufferString("{}") },
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "directory", method: http.MethodPut, target: "/srv/dir/",
+			body:   func() (string, *bytes.Buffer) { return "", bytes.NewBufferString("dir") },
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentType, body := tt.body()
+			req := httptest.NewRequest(tt.method, tt.target, body)
+			if contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("%v %v, got: %v want: %v (%s) \n", tt.method, tt.target, rec.Code, tt.status, rec.Body)
+			}
+			if tt.file == "" {
+				return
+			}
+			res := &uploadResponse{}
+			if err := json.NewDecoder(rec.Body).Decode(res); err != nil {
+				t.Fatalf("Decode(), got: %v \n", err)
+			}
+			if res.Name != tt.file || res.Link != buck.GetUrl(tt.file) {
+				t.Errorf("%v %v, got: %+v want: %v \n", tt.method, tt.target, res, tt.file)
+			}
+			if _, err := buck.Attributes(ctx, tt.file); err != nil {
+				t.Errorf("Attributes(%v), got: %v \n", tt.file, err)
+			}
+		})
+	}
+	if _, err := buck.Attributes(ctx, "large.txt"); err == nil {
+		t.Error("Attributes(large.txt) should fail, as upload is aborted")
+	}
+
+	_, readOnly, _ := BucketRouteAndHandler(buck)
+	rec := serve(t, readOnly, http.MethodPut, "/srv/put/one.txt", nil)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT /srv/put/one.txt, got: %v want: %v \n", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -26,7 +26,7 @@ func main() {
 		"/srv/dir/buzz.txt":           http.StatusNotFound,
 		"/srv/new/dir":                http.StatusNotFound,
 		"/srv/new/dir/fuzz.txt":       http.StatusOK,
-		"/srv/new/dir/fuzz.txt.attrs": http.StatusOK,
+		"/srv/new/dir/fuzz.txt.attrs": http.StatusNotFound,
 	}
 
 	now := time.Now()
@@ -0,0 +1,198 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"google.golang.org/api/googleapi"
+)
+
+// PreconditionError is returned by the writes whose IfNotExists or IfMatch
+// precondition fails
+type PreconditionError struct {
+	// Name of the file written
+	Name string
+	// ETag of the existing file, empty if it does not exist (or if unknown,
+	// when the precondition is checked by the provider)
+	ETag string
+}
+
+func (e *PreconditionError) Error() string {
+	if e.ETag == "" {
+		return fmt.Sprintf("bucket: precondition failed for %q", e.Name)
+	}
+	return fmt.Sprintf("bucket: precondition failed for %q (etag %v)", e.Name, e.ETag)
+}
+
+// staleLock is the age after which a lock file left by a crashed process is
+// removed, the lock files being refreshed while held
+var staleLock = time.Minute
+
+// hasPreconditions reports whether the write has any precondition
+func (o *WriteOptions) hasPreconditions() bool {
+	return o != nil && (o.IfNotExists || o.IfMatch != "")
+}
+
+// checkPreconditions checks the preconditions of the write of the file name
+// against its current attributes, and returns the options for the blob writer.
+// The preconditions of the GCS buckets are also enforced by the provider, the
+// others rely on the writes being serialised by lockWrite.
+func (b *Bucket) checkPreconditions(ctx context.Context, name string, opts *WriteOptions) (*blob.WriterOptions, error) {
+	wopts := opts.writerOptions()
+	attrs, err := b.bucket.Attributes(ctx, name)
+	if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return nil, err
+	}
+	if opts.IfNotExists && attrs != nil || opts.IfMatch != "" && (attrs == nil || attrs.ETag != opts.IfMatch) {
+		e := &PreconditionError{Name: name}
+		if attrs != nil {
+			e.ETag = attrs.ETag
+		}
+		return nil, e
+	}
+	if b.provider != GoogleCloud {
+		return wopts, nil
+	}
+
+	cond := storage.Conditions{DoesNotExist: true}
+	if attrs != nil {
+		var oa storage.ObjectAttrs
+		if !attrs.As(&oa) {
+			return wopts, nil
+		}
+		cond = storage.Conditions{GenerationMatch: oa.Generation}
+	}
+	wopts.BeforeWrite = func(as func(interface{}) bool) error {
+		var obj **storage.ObjectHandle
+		if as(&obj) {
+			*obj = (*obj).If(cond)
+		}
+		return nil
+	}
+	return wopts, nil
+}
+
+// preconditionError converts the precondition failures reported by the
+// provider into PreconditionError
+func preconditionError(name string, err error) error {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
+		return &PreconditionError{Name: name}
+	}
+	return err
+}
+
+// lockWrite serialises the conditional writes of the file name, within the
+// process and, for the file system buckets, across the processes of the host
+// using a lock file, and returns the unlock function
+func (b *Bucket) lockWrite(ctx context.Context, name string) (func(), error) {
+	unlock := b.locks.Lock(name)
+	path := b.localPath(name)
+	if path == "" {
+		return unlock, nil
+	}
+	sum := sha256.Sum256([]byte(path))
+	lockPath := filepath.Join(os.TempDir(), "upload-"+hex.EncodeToString(sum[:16])+".lock")
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		unlock()
+		return nil, err
+	}
+	for {
+		err := createLock(lockPath, token)
+		if err == nil {
+			stop := refreshLock(lockPath)
+			return func() {
+				stop()
+				// the lock is removed only if still owned
+				if held, err := ioutil.ReadFile(lockPath); err == nil && bytes.Equal(held, token) {
+					_ = os.Remove(lockPath)
+				}
+				unlock()
+			}, nil
+		}
+		if !os.IsExist(err) {
+			unlock()
+			return nil, err
+		}
+		if st, err := os.Stat(lockPath); err == nil && time.Since(st.ModTime()) > staleLock {
+			_ = os.Remove(lockPath)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			unlock()
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// createLock creates the lock file at path, holding the token of its owner
+func createLock(path string, token []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(token)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
+// refreshLock refreshes the modification time of the lock file at path until
+// the returned function is called, so that the lock is never stale while held
+func refreshLock(path string) func() {
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(staleLock / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				_ = os.Chtimes(path, now, now)
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// localPath returns the path of the file name for the file system buckets
+func (b *Bucket) localPath(name string) string {
+	switch b.provider {
+	case FileSystem:
+		// the url can have options, e.g. create_dir
+		u, err := url.Parse(b.url)
+		if err != nil {
+			return ""
+		}
+		return filepath.Join(filepath.FromSlash(u.Path), filepath.FromSlash(name))
+	case ProxiedFileSystem:
+		return filepath.Join(b.GetMetadata("storage"), filepath.FromSlash(name))
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPreconditions(t *testing.T) {
+	ctx := context.Background()
+	for _, url := range []string{"mem://", "file://" + t.TempDir()} {
+		bucket := NewBucket(url)
+
+		// only one of the concurrent create-only writes succeeds
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := bucket.Upload(ctx, "one.in", strings.NewReader("one.in"), &WriteOptions{IfNotExists: true})
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		created := 0
+		for err := range errs {
+			var perr *PreconditionError
+			switch {
+			case err == nil:
+				created++
+			case !errors.As(err, &perr) || perr.Name != "one.in" || perr.ETag == "":
+				t.Errorf("%v: Upload(one.in, IfNotExists), got: %v want: *PreconditionError \n", url, err)
+			}
+		}
+		if created != 1 {
+			t.Errorf("%v: Upload(one.in, IfNotExists), created: %v want: 1 \n", url, created)
+		}
+
+		attrs, err := bucket.Attributes(ctx, "one.in")
+		if err != nil {
+			t.Fatalf("%v: Attributes(one.in), got: %v \n", url, err)
+		}
+		tests := []struct {
+			name    string
+			opts    *WriteOptions
+			content string
+			failed  bool
+		}{
+			{name: "one.in", opts: &WriteOptions{IfMatch: `"stale"`}, content: "stale", failed: true},
+			{name: "one.in", opts: &WriteOptions{IfMatch: attrs.ETag}, content: "swapped"},
+			{name: "one.in", opts: &WriteOptions{IfMatch: attrs.ETag}, content: "again", failed: true},
+			{name: "two.in", opts: &WriteOptions{IfMatch: attrs.ETag}, content: "missing", failed: true},
+			{name: "two.in", opts: &WriteOptions{IfNotExists: true}, content: "created"},
+		}
+		for _, tt := range tests {
+			_, err := bucket.Upload(ctx, tt.name, strings.NewReader(tt.content), tt.opts)
+			var perr *PreconditionError
+			if failed := errors.As(err, &perr); failed != tt.failed || err != nil && !failed {
+				t.Errorf("%v: Upload(%v, %+v), got: %v want failed: %v \n", url, tt.name, tt.opts, err, tt.failed)
+			}
+		}
+		for name, want := range map[string]string{"one.in": "swapped", "two.in": "created"} {
+			if con, err := bucket.ReadAll(ctx, name); err != nil || string(con) != want {
+				t.Errorf("%v: ReadAll(%v), got: %s, %v want: %v \n", url, name, con, err, want)
+			}
+		}
+	}
+}
+
+func TestLockRefresh(t *testing.T) {
+	defer func(d time.Duration) { staleLock = d }(staleLock)
+	staleLock = 40 * time.Millisecond
+	ctx := context.Background()
+	dir := t.TempDir()
+	// the buckets do not share the in-process locks, as distinct processes
+	first, second := NewBucket("file://"+dir), NewBucket("file://"+dir)
+
+	r, w := io.Pipe()
+	done := make(chan error)
+	go func() {
+		_, err := first.Upload(ctx, "one.in", r, &WriteOptions{IfNotExists: true})
+		done <- err
+	}()
+	// the write holds the lock once it reads the content
+	if _, err := w.Write(make([]byte, 4096)); err != nil {
+		t.Fatalf("Write(), got: %v \n", err)
+	}
+	secondDone := make(chan error)
+	go func() {
+		_, err := second.Upload(ctx, "one.in", strings.NewReader("second"), &WriteOptions{IfNotExists: true})
+		secondDone <- err
+	}()
+	time.Sleep(5 * staleLock)
+	_ = w.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Upload(one.in), got: %v \n", err)
+	}
+	var perr *PreconditionError
+	if err := <-secondDone; !errors.As(err, &perr) {
+		t.Errorf("Upload(one.in), got: %v want: *PreconditionError \n", err)
+	}
+	if attrs, err := first.Attributes(ctx, "one.in"); err != nil || attrs.Size != 4096 {
+		t.Errorf("Attributes(one.in), got: %v, %v want: 4096 bytes \n", attrs, err)
+	}
+}
@@ -0,0 +1,274 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocloud.dev/gcerrors"
+)
+
+// RekeyOptions configures Rekey
+type RekeyOptions struct {
+	// Progress, if set, is called after each file under the prefix is
+	// processed, whether it is rewritten or skipped
+	Progress func(p RekeyProgress)
+}
+
+// RekeyProgress reports the progress of Rekey
+type RekeyProgress struct {
+	// Name of the file processed
+	Name string
+	// Rewritten reports whether the file has been rewritten, it is false for
+	// the files already encrypted using the new key (but the one being
+	// rewritten by the interrupted run resumed), or written concurrently
+	Rewritten bool
+	// Done is the number of files processed so far, out of Total, not counting
+	// the ones processed by the interrupted runs resumed
+	Done, Total int
+}
+
+// RekeyResult is the audit of Rekey
+type RekeyResult struct {
+	// Rewritten are the names of the files rewritten using the new key, in
+	// order, including the ones rewritten by the interrupted runs resumed
+	Rewritten []string
+	// Skipped are the names of the files skipped by this run
+	Skipped []string
+}
+
+// rekeyBatch is the number of names of the files rewritten kept in the
+// checkpoint of Rekey, before being stored as a batch of its audit
+var rekeyBatch = 100
+
+// rekeyCheckpoint is the persisted state of Rekey, used to resume it
+type rekeyCheckpoint struct {
+	Prefix  string    `json:"prefix"`
+	KeyID   string    `json:"keyId"`
+	Started time.Time `json:"started"`
+	// After is the name of the last file processed, the files are processed
+	// in the order of their names
+	After string `json:"after"`
+	// Batches is the number of batches of the names of the files rewritten
+	// stored, and Pending are the names not stored in a batch yet
+	Batches int      `json:"batches"`
+	Pending []string `json:"pending"`
+	// InFlight is the name of the file being rewritten, recorded before
+	// rewriting it, so that a resumed run counts it as rewritten if it is
+	// already encrypted using the new key
+	InFlight string `json:"inFlight"`
+}
+
+// Rekey rewrites every file of the bucket under the prefix, encrypting it
+// using the key newKeyID, e.g. once the previous key is compromised, and
+// returns the audit of the files rewritten. The key provider of the bucket must
+// be set (see SetEncryption) and able to wrap the keys using newKeyID, and the
+// key of the bucket is left unchanged: the files written after are encrypted
+// using newKeyID once the buckets are set up with it.
+//
+// Each file is streamed through Bucket.Reader and written back with its
+// content type, metadata and other attributes, with a new data key. The files
+// already encrypted using newKeyID are skipped, as are the ones changed while
+// being rewritten. The progress is checkpointed into the staging prefix of the
+// bucket (see SetStagingPrefix) before each file, and the names of the files
+// rewritten are stored there in batches, so that an interrupted Rekey of the
+// prefix resumes from the file being rewritten. They are deleted once done.
+func Rekey(ctx context.Context, bucket *Bucket, prefix, newKeyID string, opts *RekeyOptions) (*RekeyResult, error) {
+	if bucket.keys == nil {
+		return nil, errors.New("bucket: encryption is not set")
+	}
+	if newKeyID == "" {
+		return nil, errors.New("bucket: new key id is required")
+	}
+	// fails early if the key is unknown to the provider
+	if _, err := bucket.keys.WrapKey(ctx, newKeyID, make([]byte, dataKeySize)); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(prefix))
+	id := hex.EncodeToString(sum[:16])
+	name := bucket.stagingName("rekey", id+".json")
+	batchName := func(n int) string {
+		return bucket.stagingName("rekey", id+"-"+strconv.Itoa(n)+".json")
+	}
+	cp, err := bucket.rekeyCheckpoint(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if cp == nil || cp.Prefix != prefix || cp.KeyID != newKeyID {
+		cp = &rekeyCheckpoint{Prefix: prefix, KeyID: newKeyID, Started: time.Now().UTC()}
+	}
+
+	objs, err := bucket.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	internal, journal := bucket.stagingName("rekey", "")+"/", bucket.stagingName("temp", "")+"/"
+	var names []string
+	for _, obj := range objs {
+		if !obj.IsDir && !strings.HasPrefix(obj.Key, internal) && !strings.HasPrefix(obj.Key, journal) && obj.Key > cp.After {
+			names = append(names, obj.Key)
+		}
+	}
+	res := &RekeyResult{}
+	// audit returns the names of the files rewritten, so far
+	audit := func() ([]string, error) {
+		var rewritten []string
+		for n := 0; n < cp.Batches; n++ {
+			data, err := bucket.ReadAll(ctx, batchName(n))
+			if err != nil {
+				return nil, err
+			}
+			var batch []string
+			if err := json.Unmarshal(data, &batch); err != nil {
+				return nil, err
+			}
+			rewritten = append(rewritten, batch...)
+		}
+		return append(rewritten, cp.Pending...), nil
+	}
+	fail := func(err error) (*RekeyResult, error) {
+		res.Rewritten, _ = audit()
+		return res, err
+	}
+	resumed := cp.InFlight
+	for i, key := range names {
+		cp.InFlight = key
+		if err := bucket.writeJSON(ctx, name, cp); err != nil {
+			return fail(err)
+		}
+		rewritten, err := bucket.rekey(ctx, key, newKeyID)
+		if err != nil {
+			return fail(err)
+		}
+		if !rewritten && key == resumed {
+			// rewritten by the interrupted run, before its checkpoint
+			attrs, err := bucket.Attributes(ctx, key)
+			if err != nil {
+				return fail(err)
+			}
+			rewritten = attrs.Metadata[MetadataKeyID] == newKeyID
+		}
+		cp.After = key
+		if rewritten {
+			cp.Pending = append(cp.Pending, key)
+		} else {
+			res.Skipped = append(res.Skipped, key)
+		}
+		if len(cp.Pending) == rekeyBatch {
+			// stored before the checkpoint referring to it, a resumed
+			// run stores it again if interrupted in between
+			if err := bucket.writeJSON(ctx, batchName(cp.Batches), cp.Pending); err != nil {
+				return fail(err)
+			}
+			cp.Batches, cp.Pending = cp.Batches+1, nil
+		}
+		if opts != nil && opts.Progress != nil {
+			opts.Progress(RekeyProgress{Name: key, Rewritten: rewritten, Done: i + 1, Total: len(names)})
+		}
+	}
+
+	cp.InFlight = ""
+	if err := bucket.writeJSON(ctx, name, cp); err != nil {
+		return fail(err)
+	}
+	if res.Rewritten, err = audit(); err != nil {
+		return res, err
+	}
+	// the checkpoint is deleted last, for an interrupted deletion to resume
+	objs, err = bucket.List(ctx, bucket.stagingName("rekey", id))
+	if err != nil {
+		return res, err
+	}
+	for _, obj := range objs {
+		if obj.Key != name {
+			if err := bucket.Delete(ctx, obj.Key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+				return res, err
+			}
+		}
+	}
+	if err := bucket.Delete(ctx, name); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return res, err
+	}
+	return res, nil
+}
+
+// rekeyCheckpoint returns the checkpoint of Rekey stored in the file name, if any
+func (b *Bucket) rekeyCheckpoint(ctx context.Context, name string) (*rekeyCheckpoint, error) {
+	data, err := b.ReadAll(ctx, name)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := &rekeyCheckpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// writeJSON writes the JSON encoding of v into the file name
+func (b *Bucket) writeJSON(ctx context.Context, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteAll(ctx, name, data)
+	return err
+}
+
+// rekey rewrites the file name using the key keyID, unless it is already
+// encrypted using it, and reports whether it has been rewritten
+func (b *Bucket) rekey(ctx context.Context, name, keyID string) (bool, error) {
+	attrs, err := b.Attributes(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if attrs.Metadata[MetadataKeyID] == keyID {
+		return false, nil
+	}
+	metadata := make(map[string]string, len(attrs.Metadata))
+	for k, v := range attrs.Metadata {
+		if k != MetadataKeyID && k != MetadataDataKey && k != MetadataSealedSHA256 {
+			metadata[k] = v
+		}
+	}
+	if attrs.Metadata[MetadataKeyID] != "" {
+		// verified while rewritten, and sealed using the new data key
+		digest, err := b.sealedDigest(ctx, attrs)
+		if err != nil {
+			return false, err
+		}
+		if len(digest) > 0 {
+			metadata[MetadataSHA256] = hex.EncodeToString(digest)
+		}
+	}
+	r, err := b.Reader(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	_, err = b.Upload(ctx, name, r, &WriteOptions{
+		ContentType:        attrs.ContentType,
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		Metadata:           metadata,
+		// skipped if the file changed since
+		IfMatch: attrs.ETag,
+		keyID:   keyID,
+	})
+	var perr *PreconditionError
+	if errors.As(err, &perr) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,165 @@
+package upload
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRekey(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keys := NewKeyring()
+	for _, id := range []string{"k1", "k2"} {
+		key := make([]byte, 32)
+		_, _ = rand.Read(key)
+		if err := keys.Add(id, key); err != nil {
+			t.Fatalf("Add(%v), got: %v \n", id, err)
+		}
+	}
+	plain := NewBucket("file://" + dir)
+	bucket := NewBucket("file://" + dir)
+	bucket.SetEncryption(keys, "k1")
+	bucket.SetVerifyOnRead(true)
+	if _, err := Rekey(ctx, plain, "docs/", "k2", nil); err == nil {
+		t.Errorf("Rekey(plain), got: nil want: encryption is not set \n")
+	}
+	// an unknown key fails before anything is rewritten
+	if _, err := Rekey(ctx, bucket, "docs/", "k3", nil); err == nil {
+		t.Errorf("Rekey(k3), got: nil want: unknown key \n")
+	}
+	// the audit is stored in batches of a single file
+	defer func(n int) { rekeyBatch = n }(rekeyBatch)
+	rekeyBatch = 1
+
+	files := map[string]string{"docs/a": "alpha", "docs/b": "<html>beta</html>", "docs/c": "gamma", "other": "other"}
+	for name, content := range files {
+		opts := &WriteOptions{CacheControl: "no-cache", Metadata: map[string]string{"owner": name}}
+		if _, err := bucket.Upload(ctx, name, strings.NewReader(content), opts); err != nil {
+			t.Fatalf("Upload(%v), got: %v \n", name, err)
+		}
+	}
+	files["docs/d"] = "delta"
+	if _, err := plain.WriteAll(ctx, "docs/d", []byte("delta")); err != nil {
+		t.Fatalf("WriteAll(docs/d), got: %v \n", err)
+	}
+
+	// the first run is interrupted while rewriting docs/c
+	fail := true
+	bucket.Use(func(next Op) Op {
+		return func(ctx context.Context, op *Operation) error {
+			if op.Kind == OpWrite && op.Name == "docs/c" && fail {
+				fail = false
+				return errors.New("interrupted")
+			}
+			return next(ctx, op)
+		}
+	})
+	var progress []RekeyProgress
+	opts := &RekeyOptions{Progress: func(p RekeyProgress) { progress = append(progress, p) }}
+	res, err := Rekey(ctx, bucket, "docs/", "k2", opts)
+	if err == nil || !reflect.DeepEqual(res.Rewritten, []string{"docs/a", "docs/b"}) {
+		t.Errorf("Rekey(docs/), got: %v, %v want: [docs/a docs/b], interrupted \n", res, err)
+	}
+
+	progress = nil
+	res, err = Rekey(ctx, bucket, "docs/", "k2", opts)
+	if err != nil || !reflect.DeepEqual(res.Rewritten, []string{"docs/a", "docs/b", "docs/c", "docs/d"}) || len(res.Skipped) != 0 {
+		t.Errorf("Rekey(docs/), got: %+v, %v want: [docs/a docs/b docs/c docs/d] \n", res, err)
+	}
+	want := []RekeyProgress{
+		{Name: "docs/c", Rewritten: true, Done: 1, Total: 2},
+		{Name: "docs/d", Rewritten: true, Done: 2, Total: 2},
+	}
+	if !reflect.DeepEqual(progress, want) {
+		t.Errorf("Rekey(docs/), progress got: %v want: %v \n", progress, want)
+	}
+	if objs, err := plain.List(ctx, bucket.stagingName("rekey", "")); err != nil || len(objs) != 0 {
+		t.Errorf("List(checkpoints), got: %v, %v want: none \n", len(objs), err)
+	}
+
+	for name, content := range files {
+		keyID := "k2"
+		if name == "other" {
+			keyID = "k1"
+		}
+		attrs, err := plain.Attributes(ctx, name)
+		if err != nil || attrs.Metadata[MetadataKeyID] != keyID {
+			t.Errorf("Attributes(%v), got: %v, %v want key id: %v \n", name, attrs, err, keyID)
+			continue
+		}
+		if name != "docs/d" && (attrs.CacheControl != "no-cache" || attrs.Metadata["owner"] != name) {
+			t.Errorf("Attributes(%v), got: %v want cache control and metadata preserved \n", name, attrs)
+		}
+		if attrs.Metadata[MetadataSHA256] != "" || attrs.Metadata[MetadataSealedSHA256] == "" {
+			t.Errorf("Attributes(%v), got metadata: %v want: sealed digest only \n", name, attrs.Metadata)
+		}
+		if got, err := bucket.ReadAll(ctx, name); err != nil || string(got) != content {
+			t.Errorf("ReadAll(%v), got: %q, %v want: %q \n", name, got, err, content)
+		}
+	}
+	if attrs, err := bucket.Attributes(ctx, "docs/b"); err != nil || attrs.ContentType != "text/html; charset=utf-8" {
+		t.Errorf("Attributes(docs/b), got: %v, %v want: text/html \n", attrs, err)
+	}
+
+	// the key of the bucket is left unchanged
+	if _, err := bucket.WriteAll(ctx, "docs/e", []byte("epsilon")); err != nil {
+		t.Fatalf("WriteAll(docs/e), got: %v \n", err)
+	}
+	if attrs, err := plain.Attributes(ctx, "docs/e"); err != nil || attrs.Metadata[MetadataKeyID] != "k1" {
+		t.Errorf("Attributes(docs/e), got: %v, %v want key id: k1 \n", attrs, err)
+	}
+	if err := bucket.Delete(ctx, "docs/e"); err != nil {
+		t.Fatalf("Delete(docs/e), got: %v \n", err)
+	}
+
+	// the files rewritten already are skipped
+	res, err = Rekey(ctx, bucket, "docs/", "k2", nil)
+	if err != nil || len(res.Rewritten) != 0 || len(res.Skipped) != 4 {
+		t.Errorf("Rekey(docs/), got: %+v, %v want: 4 skipped \n", res, err)
+	}
+}
+
+func TestRekeyInFlight(t *testing.T) {
+	ctx := context.Background()
+	keys := NewKeyring()
+	for _, id := range []string{"k1", "k2"} {
+		key := make([]byte, 32)
+		_, _ = rand.Read(key)
+		if err := keys.Add(id, key); err != nil {
+			t.Fatalf("Add(%v), got: %v \n", id, err)
+		}
+	}
+	bucket := NewBucket("file://" + t.TempDir())
+	bucket.SetEncryption(keys, "k1")
+	for _, name := range []string{"docs/a", "docs/b", "docs/c"} {
+		if _, err := bucket.WriteAll(ctx, name, []byte(name)); err != nil {
+			t.Fatalf("WriteAll(%v), got: %v \n", name, err)
+		}
+	}
+
+	// the first run is interrupted once docs/b is rewritten, before the
+	// checkpoint recording it is written
+	rewritten, fail := false, true
+	bucket.Use(func(next Op) Op {
+		return func(ctx context.Context, op *Operation) error {
+			if op.Kind == OpWrite && strings.HasPrefix(op.Name, bucket.stagingName("rekey", "")) && rewritten && fail {
+				fail = false
+				return errors.New("interrupted")
+			}
+			err := next(ctx, op)
+			rewritten = rewritten || op.Kind == OpWrite && op.Name == "docs/b" && err == nil
+			return err
+		}
+	})
+	if res, err := Rekey(ctx, bucket, "docs/", "k2", nil); err == nil || !reflect.DeepEqual(res.Rewritten, []string{"docs/a", "docs/b"}) {
+		t.Errorf("Rekey(docs/), got: %v, %v want: [docs/a docs/b], interrupted \n", res, err)
+	}
+	res, err := Rekey(ctx, bucket, "docs/", "k2", nil)
+	if err != nil || !reflect.DeepEqual(res.Rewritten, []string{"docs/a", "docs/b", "docs/c"}) || len(res.Skipped) != 0 {
+		t.Errorf("Rekey(docs/), got: %+v, %v want: [docs/a docs/b docs/c] \n", res, err)
+	}
+}
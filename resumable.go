@@ -0,0 +1,209 @@
+package upload
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shivam010/upload/internal/parts"
+)
+
+// DefaultStagingPrefix is the prefix of the parts and state of the resumable
+// uploads, unless set using SetStagingPrefix
+const DefaultStagingPrefix = ".uploads/"
+
+// MaxParts is the maximum part number of a resumable upload
+const MaxParts = 10000
+
+// ErrInvalidUpload is returned for malformed upload ids and part numbers
+var ErrInvalidUpload = errors.New("bucket: invalid resumable upload")
+
+// ResumableUpload is the persisted state of a resumable upload
+type ResumableUpload struct {
+	// ID of the upload
+	ID string `json:"id"`
+	// Name of the file the upload is completed into
+	Name string `json:"name"`
+	// Created is the time the upload was started
+	Created time.Time `json:"created"`
+	// Parts are the parts uploaded so far, in the order of their numbers,
+	// only set by GetResumableUpload
+	Parts []UploadedPart `json:"-"`
+}
+
+// UploadedPart is a part of a resumable upload
+type UploadedPart struct {
+	// Number of the part, from 1 to MaxParts
+	Number int
+	// Size of the part in bytes
+	Size int64
+}
+
+// SetStagingPrefix sets the prefix of the parts and state of the resumable
+// uploads in the bucket, also used for the other staged files (see
+// StagingPrefix). It should be called before the bucket is put to use.
+func (b *Bucket) SetStagingPrefix(prefix string) {
+	b.staging = prefix
+}
+
+// StagingPrefix returns the prefix, ending with "/", of the files staged in
+// the bucket: the parts and state of the resumable uploads, the content being
+// addressed by WriteContentAddressed, the checkpoints of Rekey and the journal
+// of the temporary files of the file system buckets. The files under it are
+// internal, and not meant to be served.
+func (b *Bucket) StagingPrefix() string {
+	prefix := strings.Trim(b.staging, "/")
+	if prefix == "" {
+		return DefaultStagingPrefix
+	}
+	return prefix + "/"
+}
+
+// stagingName returns the name of the staged file of the upload id
+func (b *Bucket) stagingName(id, file string) string {
+	return path.Join(b.StagingPrefix(), id, file)
+}
+
+// partName returns the name of the staged part n of the upload id
+func (b *Bucket) partName(id string, n int) string {
+	return b.stagingName(id, fmt.Sprintf("part-%05d", n))
+}
+
+// NewResumableUpload starts the resumable upload of the file name and returns
+// its id. The parts of the upload are uploaded using UploadPart, possibly by
+// different processes, and are concatenated into the file by Complete.
+//
+// The parts and the state of the upload are staged in the bucket itself, under
+// the staging prefix, so that the upload can be resumed after the process
+// crashes.
+func (b *Bucket) NewResumableUpload(ctx context.Context, name string) (string, error) {
+	if name == "" {
+		return "", errors.New("bucket: name of file-content is required")
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	u := &ResumableUpload{ID: hex.EncodeToString(id), Name: name, Created: time.Now().UTC()}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return "", err
+	}
+	if _, err := b.WriteAll(ctx, b.stagingName(u.ID, "upload.json"), data); err != nil {
+		return "", err
+	}
+	return u.ID, nil
+}
+
+// GetResumableUpload returns the state of the resumable upload id, with the
+// parts uploaded so far
+func (b *Bucket) GetResumableUpload(ctx context.Context, id string) (*ResumableUpload, error) {
+	if !validUploadID(id) {
+		return nil, ErrInvalidUpload
+	}
+	data, err := b.ReadAll(ctx, b.stagingName(id, "upload.json"))
+	if err != nil {
+		return nil, err
+	}
+	u := &ResumableUpload{}
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, err
+	}
+	objs, err := b.List(ctx, b.stagingName(id, "part-"))
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		n, err := strconv.Atoi(strings.TrimPrefix(path.Base(obj.Key), "part-"))
+		if err != nil {
+			continue
+		}
+		u.Parts = append(u.Parts, UploadedPart{Number: n, Size: obj.Size})
+	}
+	sort.Slice(u.Parts, func(i, j int) bool { return u.Parts[i].Number < u.Parts[j].Number })
+	return u, nil
+}
+
+// UploadPart uploads the content of r as the part number n (from 1 to MaxParts)
+// of the resumable upload id. The parts can be uploaded in any order and
+// concurrently, uploading a part again replaces it.
+func (b *Bucket) UploadPart(ctx context.Context, id string, n int, r io.Reader) error {
+	if !validUploadID(id) || n < 1 || n > MaxParts {
+		return ErrInvalidUpload
+	}
+	if _, err := b.Attributes(ctx, b.stagingName(id, "upload.json")); err != nil {
+		return err
+	}
+	_, err := b.Upload(ctx, b.partName(id, n), r, nil)
+	return err
+}
+
+// Complete concatenates the parts of the resumable upload id, in the order of
+// their numbers, into its file, deletes the staged parts and state, and
+// returns the corresponding access url or error if any.
+//
+// On Google Cloud the parts are composed, and on Amazon S3 they are copied as
+// the parts of a multipart upload (requiring all of them but the last one to
+// be of at least 5 MiB), so that their content is not read by the process.
+// Otherwise, or if the bucket is encrypted, the parts are streamed into the
+// file.
+func (b *Bucket) Complete(ctx context.Context, id string) (string, error) {
+	u, err := b.GetResumableUpload(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if b.composable(u.Parts) {
+		op := &Operation{Kind: OpCompose, Name: u.Name, Sources: make([]string, len(u.Parts))}
+		for i, p := range u.Parts {
+			op.Sources[i] = b.partName(id, p.Number)
+		}
+		if err := b.do(ctx, op); !errors.Is(err, errComposeUnsupported) {
+			if err != nil {
+				return "", err
+			}
+			return b.GetUrl(u.Name), b.Abort(ctx, id)
+		}
+	}
+	content := parts.NewReader(len(u.Parts), func(i int) (io.ReadCloser, error) {
+		return b.Reader(ctx, b.partName(id, u.Parts[i].Number))
+	})
+	link, err := b.Upload(ctx, u.Name, content, nil)
+	_ = content.Close()
+	if err != nil {
+		return "", err
+	}
+	return link, b.Abort(ctx, id)
+}
+
+// Abort deletes the staged parts and state of the resumable upload id
+func (b *Bucket) Abort(ctx context.Context, id string) error {
+	u, err := b.GetResumableUpload(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, p := range u.Parts {
+		if err := b.Delete(ctx, b.partName(id, p.Number)); err != nil {
+			return err
+		}
+	}
+	return b.Delete(ctx, b.stagingName(id, "upload.json"))
+}
+
+// validUploadID reports whether id can be an upload id, so that it cannot
+// refer to the files out of the staging prefix
+func validUploadID(id string) bool {
+	if id == "" {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
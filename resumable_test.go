@@ -0,0 +1,109 @@
+package upload
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestResumableUpload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bucket := NewBucket("file://" + dir)
+	id, err := bucket.NewResumableUpload(ctx, "dir/big.txt")
+	if err != nil {
+		t.Fatalf("NewResumableUpload(dir/big.txt), got: %v \n", err)
+	}
+	for n, part := range map[int]string{3: "three", 1: "one-", 2: "TWO-"} {
+		if err := bucket.UploadPart(ctx, id, n, strings.NewReader(part)); err != nil {
+			t.Fatalf("UploadPart(%v), got: %v \n", n, err)
+		}
+	}
+	// part replaced after the process "crashed", using a new bucket
+	bucket = NewBucket("file://" + dir)
+	if err := bucket.UploadPart(ctx, id, 2, strings.NewReader("two-")); err != nil {
+		t.Fatalf("UploadPart(2), got: %v \n", err)
+	}
+	u, err := bucket.GetResumableUpload(ctx, id)
+	if err != nil {
+		t.Fatalf("GetResumableUpload(%v), got: %v \n", id, err)
+	}
+	if u.Name != "dir/big.txt" || len(u.Parts) != 3 || u.Parts[0] != (UploadedPart{Number: 1, Size: 4}) || u.Parts[2].Number != 3 {
+		t.Errorf("GetResumableUpload(%v), got: %+v \n", id, u)
+	}
+
+	tests := []struct {
+		id string
+		n  int
+	}{
+		{id: id, n: 0},
+		{id: id, n: MaxParts + 1},
+		{id: "../dir", n: 1},
+		{id: "", n: 1},
+	}
+	for _, tt := range tests {
+		if err := bucket.UploadPart(ctx, tt.id, tt.n, strings.NewReader("x")); err != ErrInvalidUpload {
+			t.Errorf("UploadPart(%v, %v), got: %v want: %v \n", tt.id, tt.n, err, ErrInvalidUpload)
+		}
+	}
+	if err := bucket.UploadPart(ctx, "abcd", 1, strings.NewReader("x")); err == nil {
+		t.Errorf("UploadPart(abcd, 1), got: nil want: not found \n")
+	}
+
+	link, err := bucket.Complete(ctx, id)
+	if err != nil || link != bucket.GetUrl("dir/big.txt") {
+		t.Fatalf("Complete(%v), got: %v, %v \n", id, link, err)
+	}
+	if con, err := bucket.ReadAll(ctx, "dir/big.txt"); err != nil || string(con) != "one-two-three" {
+		t.Errorf("ReadAll(dir/big.txt), got: %s, %v want: one-two-three \n", con, err)
+	}
+	if objs, err := bucket.List(ctx, DefaultStagingPrefix); err != nil || len(objs) != 0 {
+		t.Errorf("List(%v), got: %v, %v want: none \n", DefaultStagingPrefix, len(objs), err)
+	}
+
+	bucket.SetStagingPrefix("staging/")
+	id, err = bucket.NewResumableUpload(ctx, "aborted.txt")
+	if err != nil {
+		t.Fatalf("NewResumableUpload(aborted.txt), got: %v \n", err)
+	}
+	if err := bucket.UploadPart(ctx, id, 1, strings.NewReader("one")); err != nil {
+		t.Fatalf("UploadPart(1), got: %v \n", err)
+	}
+	if objs, _ := bucket.List(ctx, "staging/"+id); len(objs) != 2 {
+		t.Errorf("List(staging/%v), got: %v want: 2 \n", id, len(objs))
+	}
+	if err := bucket.Abort(ctx, id); err != nil {
+		t.Fatalf("Abort(%v), got: %v \n", id, err)
+	}
+	if _, err := bucket.GetResumableUpload(ctx, id); err == nil {
+		t.Errorf("GetResumableUpload(%v), got: nil want: not found \n", id)
+	}
+	if _, err := bucket.Attributes(ctx, "aborted.txt"); err == nil {
+		t.Errorf("Attributes(aborted.txt), got: nil want: not found \n")
+	}
+}
+
+func TestComposable(t *testing.T) {
+	small, big := UploadedPart{Number: 1, Size: 4}, UploadedPart{Number: 1, Size: minPartSizeS3}
+	tests := []struct {
+		url   string
+		parts []UploadedPart
+		want  bool
+	}{
+		{url: "gs://name", parts: []UploadedPart{small, small}, want: true},
+		{url: "gs://name", parts: nil, want: false},
+		{url: "s3://name?region=us-east-2", parts: []UploadedPart{big, small}, want: true},
+		{url: "s3://name?region=us-east-2", parts: []UploadedPart{small, big}, want: false},
+		{url: "s3://name?region=us-east-2", parts: []UploadedPart{small}, want: true},
+		{url: "file:///tmp", parts: []UploadedPart{big, big}, want: false},
+		{url: "mem://", parts: []UploadedPart{big, big}, want: false},
+	}
+	for _, tt := range tests {
+		if got := NewBucket(tt.url).composable(tt.parts); got != tt.want {
+			t.Errorf("composable(%v, %v), got: %v want: %v \n", tt.url, tt.parts, got, tt.want)
+		}
+	}
+	if nativeKey("a/../b") || nativeKey("a//b") || nativeKey("a\nb") || !nativeKey(".uploads/ab/part-00001") {
+		t.Errorf("nativeKey, got: unexpected result \n")
+	}
+}
@@ -0,0 +1,116 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// Timeouts are the default timeouts of the operations on the bucket, they are
+// applied only when the context provided by the caller has no deadline.
+// A zero value means no timeout.
+type Timeouts struct {
+	// Open is the timeout for opening the bucket connection
+	Open time.Duration
+	// Read is the timeout for OpRead and OpStat, for OpRead it covers
+	// reading the content until the reader is closed
+	Read time.Duration
+	// Write is the timeout for OpWrite, OpCopy and OpCompose
+	Write time.Duration
+	// Delete is the timeout for OpDelete
+	Delete time.Duration
+	// List is the timeout for OpList
+	List time.Duration
+}
+
+// SetTimeouts sets the default timeouts of the operations on the bucket.
+// It should be called before the bucket is put to use.
+func (b *Bucket) SetTimeouts(t Timeouts) {
+	b.timeouts = t
+}
+
+// timeout returns the default timeout of the operation kind
+func (t Timeouts) timeout(kind OpKind) time.Duration {
+	switch kind {
+	case OpRead, OpStat:
+		return t.Read
+	case OpWrite, OpCopy, OpCompose:
+		return t.Write
+	case OpDelete:
+		return t.Delete
+	case OpList:
+		return t.List
+	}
+	return 0
+}
+
+// withTimeout returns the context with timeout d, if d is non-zero and ctx has no deadline
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// openBucket opens the blob bucket, giving up after the Open timeout if ctx
+// has no deadline. The bucket is opened using ctx itself rather than a derived
+// context, as some providers keep it for later use (e.g. refreshing credentials).
+func (b *Bucket) openBucket(ctx context.Context) (_ *blob.Bucket, err error) {
+	if _, ok := ctx.Deadline(); ok || b.timeouts.Open <= 0 {
+		return blob.OpenBucket(ctx, b.url)
+	}
+	type result struct {
+		bucket *blob.Bucket
+		err    error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		bucket, err := blob.OpenBucket(ctx, b.url)
+		ch <- result{bucket: bucket, err: err}
+	}()
+
+	timer := time.NewTimer(b.timeouts.Open)
+	defer timer.Stop()
+	select {
+	case res := <-ch:
+		return res.bucket, res.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = fmt.Errorf("bucket: open timed out after %v: %w", b.timeouts.Open, context.DeadlineExceeded)
+	}
+	// close the bucket if it is opened after giving up
+	go func() {
+		if res := <-ch; res.bucket != nil {
+			_ = res.bucket.Close()
+		}
+	}()
+	return nil, err
+}
+
+// cancelReader cancels the context of the read when closed
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReader) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// contextReader stops reading once the context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
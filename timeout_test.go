@@ -0,0 +1,89 @@
+package upload
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetTimeouts(t *testing.T) {
+	ctx := context.Background()
+	bucket := NewBucket("mem://")
+	bucket.SetTimeouts(Timeouts{Read: time.Minute, Write: time.Hour})
+	deadlines := map[OpKind]time.Duration{}
+	bucket.Use(func(next Op) Op {
+		return func(ctx context.Context, op *Operation) error {
+			if d, ok := ctx.Deadline(); ok {
+				deadlines[op.Kind] = time.Until(d).Round(time.Minute)
+			}
+			return next(ctx, op)
+		}
+	})
+
+	if _, err := bucket.WriteAll(ctx, "one.in", []byte("one.in")); err != nil {
+		t.Fatalf("WriteAll(one.in), got: %v \n", err)
+	}
+	r, err := bucket.Reader(ctx, "one.in")
+	if err != nil {
+		t.Fatalf("Reader(one.in), got: %v \n", err)
+	}
+	// reader should be usable until closed
+	if con, err := io.ReadAll(r); err != nil || string(con) != "one.in" {
+		t.Errorf("ReadAll(one.in), got: %s, %v want: one.in \n", con, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Close(one.in), got: %v \n", err)
+	}
+	if err := bucket.Delete(ctx, "one.in"); err != nil {
+		t.Errorf("Delete(one.in), got: %v \n", err)
+	}
+	// caller's deadline takes precedence
+	dctx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+	if _, err := bucket.List(dctx, ""); err != nil {
+		t.Errorf("List(), got: %v \n", err)
+	}
+
+	want := map[OpKind]time.Duration{OpWrite: time.Hour, OpRead: time.Minute, OpList: 3 * time.Minute}
+	for kind, d := range want {
+		if deadlines[kind] != d {
+			t.Errorf("Deadline(%v), got: %v want: %v \n", kind, deadlines[kind], d)
+		}
+	}
+	if _, ok := deadlines[OpDelete]; ok {
+		t.Errorf("Deadline(%v), got: %v want: none \n", OpDelete, deadlines[OpDelete])
+	}
+}
+
+// cancellingReader cancels the context after the first read
+type cancellingReader struct {
+	io.Reader
+	cancel context.CancelFunc
+}
+
+func (c *cancellingReader) Read(p []byte) (int, error) {
+	defer c.cancel()
+	return c.Reader.Read(p[:1])
+}
+
+func TestWriteAbort(t *testing.T) {
+	_ = os.Mkdir("bin", 0777)
+	for _, bucketUrl := range []string{"mem://", "file://" + pwd() + "/bin"} {
+		bucket := NewBucket(bucketUrl)
+		ctx, cancel := context.WithCancel(context.Background())
+		op := &Operation{
+			Kind: OpWrite,
+			Name: "aborted.in",
+			Body: &cancellingReader{Reader: strings.NewReader("aborted.in"), cancel: cancel},
+		}
+		if err := bucket.do(ctx, op); err == nil {
+			t.Errorf("Write(%v) should fail due to cancelled context", bucketUrl)
+		}
+		if _, err := bucket.Attributes(context.Background(), "aborted.in"); err == nil {
+			t.Errorf("Write(%v) should not commit partially written content", bucketUrl)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package tracing starts OpenTelemetry spans for the upload.Bucket operations
+// and for the requests served by the pfsblob handler, propagating the context
+// so that distributed traces show where the upload time goes.
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/Shivam010/upload"
+	"github.com/Shivam010/upload/internal/httpx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer used for the spans
+const InstrumentationName = "github.com/Shivam010/upload"
+
+// Attribute keys set on the spans
+const (
+	ProviderKey   = attribute.Key("upload.provider")
+	BucketKey     = attribute.Key("upload.bucket")
+	ObjectKey     = attribute.Key("upload.object")
+	BytesKey      = attribute.Key("upload.bytes")
+	HTTPMethodKey = attribute.Key("http.method")
+	HTTPTargetKey = attribute.Key("http.target")
+	HTTPStatusKey = attribute.Key("http.status_code")
+)
+
+// Tracer starts spans for the instrumented buckets and handlers
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New returns a new Tracer using the provided trace provider, or the global
+// one if tp is nil
+func New(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &Tracer{
+		tracer:     tp.Tracer(InstrumentationName),
+		propagator: otel.GetTextMapPropagator(),
+	}
+}
+
+// Instrument adds the tracing interceptor to the bucket
+func (t *Tracer) Instrument(b *upload.Bucket) {
+	b.Use(t.Interceptor(b))
+}
+
+// Interceptor returns the upload.Interceptor starting a span for every
+// operation performed on the bucket b, spans of reads end when the reader
+// is closed
+func (t *Tracer) Interceptor(b *upload.Bucket) upload.Interceptor {
+	provider, name := b.Provider().String(), b.Name()
+	return func(next upload.Op) upload.Op {
+		return func(ctx context.Context, op *upload.Operation) error {
+			ctx, span := t.tracer.Start(ctx, "upload."+string(op.Kind), trace.WithAttributes(
+				ProviderKey.String(provider),
+				BucketKey.String(name),
+				ObjectKey.String(op.Name),
+			))
+			err := next(ctx, op)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			if err == nil && op.Kind == upload.OpRead && op.Reader != nil {
+				op.Reader = &spanReader{ReadCloser: op.Reader, span: span}
+				return nil
+			}
+			span.SetAttributes(BytesKey.Int64(op.Size))
+			span.End()
+			return err
+		}
+	}
+}
+
+// InstrumentHandler wraps the handler serving the files of bucket b, e.g.
+// the one returned by handler.BucketRouteAndHandler, starting a span for
+// every request served, using the context propagated in request headers
+func (t *Tracer) InstrumentHandler(b *upload.Bucket, h http.HandlerFunc) http.HandlerFunc {
+	provider, name := b.Provider().String(), b.Name()
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.tracer.Start(ctx, "upload.http "+r.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				ProviderKey.String(provider),
+				BucketKey.String(name),
+				HTTPMethodKey.String(r.Method),
+				HTTPTargetKey.String(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		rec := httpx.NewResponseRecorder(w)
+		h(rec, r.WithContext(ctx))
+		span.SetAttributes(HTTPStatusKey.Int(rec.Status), BytesKey.Int64(rec.Written))
+		if rec.Status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status))
+		}
+	}
+}
+
+// spanReader ends the span of the read operation when closed
+type spanReader struct {
+	io.ReadCloser
+	span trace.Span
+	n    int64
+	once sync.Once
+}
+
+func (s *spanReader) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	s.n += int64(n)
+	if err != nil && err != io.EOF {
+		s.span.RecordError(err)
+	}
+	return n, err
+}
+
+func (s *spanReader) Close() error {
+	err := s.ReadCloser.Close()
+	s.once.Do(func() {
+		s.span.SetAttributes(BytesKey.Int64(s.n))
+		s.span.End()
+	})
+	return err
+}
@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Shivam010/upload"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := New(tp)
+
+	bucket := upload.NewBucket("mem://")
+	tracer.Instrument(bucket)
+
+	handler := tracer.InstrumentHandler(bucket, func(w http.ResponseWriter, r *http.Request) {
+		if _, err := bucket.WriteAll(r.Context(), "one.in", []byte("one.in")); err != nil {
+			t.Errorf("WriteAll(one.in), got: %v \n", err)
+		}
+		if _, err := bucket.ReadAll(r.Context(), "one.in"); err != nil {
+			t.Errorf("ReadAll(one.in), got: %v \n", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/one.in", nil))
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush(), got: %v \n", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("GetSpans(), got: %v spans want: 3 \n", len(spans))
+	}
+	root := spans[2]
+	if root.Name != "upload.http PUT" {
+		t.Errorf("Name, got: %v want: %v \n", root.Name, "upload.http PUT")
+	}
+	for i, name := range []string{"upload.write", "upload.read"} {
+		span := spans[i]
+		if span.Name != name {
+			t.Errorf("Name, got: %v want: %v \n", span.Name, name)
+		}
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("Parent(%v), got: %v want: %v \n", name, span.Parent.SpanID(), root.SpanContext.SpanID())
+		}
+		attrs := map[string]string{}
+		for _, kv := range span.Attributes {
+			attrs[string(kv.Key)] = kv.Value.Emit()
+		}
+		if attrs[string(ObjectKey)] != "one.in" || attrs[string(BytesKey)] != "6" || attrs[string(ProviderKey)] != "In-Memory" {
+			t.Errorf("Attributes(%v), got: %v \n", name, attrs)
+		}
+	}
+	attrs := map[string]string{}
+	for _, kv := range root.Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs[string(HTTPStatusKey)] != "201" {
+		t.Errorf("Attributes(%v), got: %v \n", root.Name, attrs)
+	}
+}
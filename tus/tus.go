@@ -0,0 +1,401 @@
+// Package tus implements the server of the tus resumable upload protocol
+// (https://tus.io/protocols/resumable-upload.html), with the core, creation
+// and termination extensions, on top of the upload.Bucket. The received
+// chunks and the state of the uploads are stored in the bucket itself, so
+// that it works with every provider, and the upload is finalised into the
+// target file once all of its content is received.
+package tus
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Shivam010/upload"
+	"github.com/Shivam010/upload/internal/httpx"
+	"github.com/Shivam010/upload/internal/keylock"
+	"github.com/Shivam010/upload/internal/parts"
+	"gocloud.dev/gcerrors"
+)
+
+// Version of the tus protocol implemented
+const Version = "1.0.0"
+
+// Extensions of the tus protocol implemented
+const Extensions = "creation,termination"
+
+// DefaultStagingPrefix is the prefix of the chunks and state of the uploads
+// in progress, under the staging prefix of the bucket (see
+// upload.Bucket.StagingPrefix), if Options.StagingPrefix is empty
+const DefaultStagingPrefix = "tus/"
+
+// Options configures the Handler
+type Options struct {
+	// BasePath is the path the handler is served at, e.g. "/files/", the
+	// Location of the created uploads is relative to it
+	BasePath string
+	// StagingPrefix is the prefix of the chunks and state of the uploads in
+	// the bucket, it is DefaultStagingPrefix under the staging prefix of the
+	// bucket if empty. The files under it should not be served publicly.
+	StagingPrefix string
+	// MaxSize is the maximum size in bytes of an upload, there is no limit if zero
+	MaxSize int64
+	// Name returns the name of the file the upload with the id and metadata
+	// is finalised into, it is the id itself if nil
+	Name func(id string, metadata map[string]string) string
+}
+
+// Handler serves the tus protocol, storing the uploads into the bucket. The
+// requests for an upload are serialised within the handler, so a single
+// instance should serve the uploads.
+type Handler struct {
+	buck *upload.Bucket
+	opts Options
+
+	// locks serialise the requests for an upload
+	locks keylock.Locks
+}
+
+// New returns the Handler storing the uploads into the bucket, configured
+// using the options, which can be nil
+func New(buck *upload.Bucket, opts *Options) *Handler {
+	h := &Handler{buck: buck}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.StagingPrefix == "" {
+		h.opts.StagingPrefix = buck.StagingPrefix() + DefaultStagingPrefix
+	}
+	if !strings.HasSuffix(h.opts.BasePath, "/") {
+		h.opts.BasePath += "/"
+	}
+	return h
+}
+
+// info is the state of an upload, stored as JSON in the bucket
+type info struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Size     int64             `json:"size"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Parts is the number of chunks received
+	Parts int `json:"parts"`
+	// Done is set once the upload is finalised into the file Name
+	Done bool `json:"done,omitempty"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", Version)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", Version)
+		w.Header().Set("Tus-Extension", Extensions)
+		if h.opts.MaxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.opts.MaxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != Version {
+		w.Header().Set("Tus-Version", Version)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.opts.BasePath), "/")
+	if r.Method == http.MethodPost && id == "" {
+		h.create(w, r)
+		return
+	}
+	if !validID(id) {
+		http.NotFound(w, r)
+		return
+	}
+	defer h.locks.Lock(id)()
+	switch r.Method {
+	case http.MethodHead:
+		h.head(w, r, id)
+	case http.MethodPatch:
+		h.patch(w, r, id)
+	case http.MethodDelete:
+		h.terminate(w, r, id)
+	default:
+		w.Header().Set("Allow", "OPTIONS, POST, HEAD, PATCH, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// create creates a new upload, responding with its Location
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if h.opts.MaxSize > 0 && size > h.opts.MaxSize {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	id, err := newID()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	in := &info{ID: id, Name: id, Size: size, Metadata: metadata}
+	if h.opts.Name != nil {
+		in.Name = h.opts.Name(id, metadata)
+	}
+	if err := h.save(r.Context(), in); err != nil {
+		httpx.BucketError(w, err)
+		return
+	}
+	// an empty upload is complete as soon as it is created
+	if size == 0 {
+		if err := h.finalise(r.Context(), in); err != nil {
+			httpx.BucketError(w, err)
+			return
+		}
+	}
+	w.Header().Set("Location", h.opts.BasePath+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head responds with the offset of the upload
+func (h *Handler) head(w http.ResponseWriter, r *http.Request, id string) {
+	in, err := h.load(r.Context(), id)
+	if err != nil {
+		httpx.BucketError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(in.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(in.Size, 10))
+	if len(in.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", formatMetadata(in.Metadata))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch stores the chunk received at the offset of the upload, and finalises
+// the upload once all of its content is received. The content received before
+// the connection is interrupted is kept, so that the client can resume from it.
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	in, err := h.load(r.Context(), id)
+	if err != nil {
+		httpx.BucketError(w, err)
+		return
+	}
+	if offset != in.Offset || in.Done {
+		http.Error(w, "mismatched Upload-Offset", http.StatusConflict)
+		return
+	}
+
+	// the chunk is written even if the request is interrupted, so it must not
+	// be cancelled along with the request
+	ctx := context.Background()
+	body := &chunkReader{r: io.LimitReader(r.Body, in.Size-in.Offset+1)}
+	if _, err := h.buck.Upload(ctx, h.partName(id, in.Parts), body, nil); err != nil {
+		httpx.BucketError(w, err)
+		return
+	}
+	if in.Offset+body.n > in.Size {
+		_ = h.buck.Delete(ctx, h.partName(id, in.Parts))
+		http.Error(w, "chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if body.n > 0 {
+		in.Parts++
+		in.Offset += body.n
+		if err := h.save(ctx, in); err != nil {
+			httpx.BucketError(w, err)
+			return
+		}
+	}
+	if body.err != nil {
+		// the client is gone, there is no one to respond to
+		return
+	}
+	if in.Offset == in.Size {
+		if err := h.finalise(ctx, in); err != nil {
+			httpx.BucketError(w, err)
+			return
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(in.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminate deletes the upload and its chunks
+func (h *Handler) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	in, err := h.load(r.Context(), id)
+	if err != nil {
+		httpx.BucketError(w, err)
+		return
+	}
+	if err := h.deleteParts(r.Context(), in); err != nil {
+		httpx.BucketError(w, err)
+		return
+	}
+	if err := h.buck.Delete(r.Context(), h.infoName(id)); err != nil {
+		httpx.BucketError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finalise writes the chunks of the upload into its file, and deletes them.
+// The state is kept, marked as done, so that the clients can still learn the
+// upload is complete, until it is terminated.
+func (h *Handler) finalise(ctx context.Context, in *info) error {
+	opts := &upload.WriteOptions{ContentType: in.Metadata["filetype"]}
+	content := parts.NewReader(in.Parts, func(i int) (io.ReadCloser, error) {
+		return h.buck.Reader(ctx, h.partName(in.ID, i))
+	})
+	defer content.Close()
+	if _, err := h.buck.Upload(ctx, in.Name, content, opts); err != nil {
+		return err
+	}
+	if err := h.deleteParts(ctx, in); err != nil {
+		return err
+	}
+	in.Done, in.Parts = true, 0
+	return h.save(ctx, in)
+}
+
+func (h *Handler) deleteParts(ctx context.Context, in *info) error {
+	for i := 0; i < in.Parts; i++ {
+		if err := h.buck.Delete(ctx, h.partName(in.ID, i)); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) load(ctx context.Context, id string) (*info, error) {
+	data, err := h.buck.ReadAll(ctx, h.infoName(id))
+	if err != nil {
+		return nil, err
+	}
+	in := &info{}
+	if err := json.Unmarshal(data, in); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+func (h *Handler) save(ctx context.Context, in *info) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	_, err = h.buck.WriteAll(ctx, h.infoName(in.ID), data)
+	return err
+}
+
+func (h *Handler) infoName(id string) string {
+	return path.Join(h.opts.StagingPrefix, id, "info")
+}
+
+func (h *Handler) partName(id string, part int) string {
+	return path.Join(h.opts.StagingPrefix, id, fmt.Sprintf("part-%08d", part))
+}
+
+// chunkReader reads the chunk from the request body, ending it at the first
+// error, so that the content received until then is kept
+type chunkReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF {
+		c.err = err
+		err = io.EOF
+	}
+	return n, err
+}
+
+// parseMetadata parses the Upload-Metadata header, the comma separated pairs
+// of keys and base64 encoded values
+func parseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		fields := strings.Fields(pair)
+		if len(fields) > 2 {
+			return nil, errors.New("tus: invalid metadata pair")
+		}
+		val := ""
+		if len(fields) == 2 {
+			dec, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			val = string(dec)
+		}
+		metadata[fields[0]] = val
+	}
+	return metadata, nil
+}
+
+// formatMetadata formats the metadata as the Upload-Metadata header
+func formatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(metadata[key])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// idSize is the number of random bytes of the upload ids
+const idSize = 16
+
+func newID() (string, error) {
+	id := make([]byte, idSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// validID reports whether id can be an upload id, as returned by newID, so
+// that it cannot refer to the files out of the staging prefix
+func validID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == idSize
+}
@@ -0,0 +1,125 @@
+package tus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Shivam010/upload"
+)
+
+// brokenReader returns the content, and then fails as an interrupted connection
+type brokenReader struct {
+	r io.Reader
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestHandler(t *testing.T) {
+	buck := upload.NewBucket("mem://")
+	h := New(buck, &Options{
+		BasePath: "/files",
+		MaxSize:  100,
+		Name: func(id string, metadata map[string]string) string {
+			return "uploads/" + metadata["filename"]
+		},
+	})
+	do := func(method, target string, body io.Reader, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, body)
+		req.Header.Set("Tus-Resumable", Version)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	patch := func(offset string) map[string]string {
+		return map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": offset}
+	}
+
+	rec := do(http.MethodOptions, "/files/", nil, nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Tus-Extension") != Extensions || rec.Header().Get("Tus-Max-Size") != "100" {
+		t.Errorf("OPTIONS, got: %v %v \n", rec.Code, rec.Header())
+	}
+	if rec := do(http.MethodPost, "/files/", nil, map[string]string{"Upload-Length": "101"}); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST (too large), got: %v want: %v \n", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	rec = do(http.MethodPost, "/files/", nil, map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": "filename aGVsbG8udHh0,filetype dGV4dC9wbGFpbg==",
+	})
+	location := rec.Header().Get("Location")
+	if rec.Code != http.StatusCreated || !strings.HasPrefix(location, "/files/") {
+		t.Fatalf("POST, got: %v %v want: %v \n", rec.Code, location, http.StatusCreated)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		body   io.Reader
+		header map[string]string
+		status int
+		offset string
+	}{
+		{name: "head", method: http.MethodHead, status: http.StatusOK, offset: "0"},
+		{name: "content type", method: http.MethodPatch, body: strings.NewReader("hello"), header: map[string]string{"Upload-Offset": "0"}, status: http.StatusUnsupportedMediaType},
+		{name: "patch", method: http.MethodPatch, body: strings.NewReader("hello"), header: patch("0"), status: http.StatusNoContent, offset: "5"},
+		{name: "conflict", method: http.MethodPatch, body: strings.NewReader("hello"), header: patch("0"), status: http.StatusConflict},
+		{name: "interrupted", method: http.MethodPatch, body: &brokenReader{r: strings.NewReader(" wo")}, header: patch("5"), status: http.StatusOK},
+		{name: "resume", method: http.MethodHead, status: http.StatusOK, offset: "8"},
+		{name: "too large", method: http.MethodPatch, body: strings.NewReader("rld!"), header: patch("8"), status: http.StatusRequestEntityTooLarge},
+		{name: "complete", method: http.MethodPatch, body: strings.NewReader("rld"), header: patch("8"), status: http.StatusNoContent, offset: "11"},
+		{name: "done", method: http.MethodHead, status: http.StatusOK, offset: "11"},
+	}
+	for _, tt := range tests {
+		rec := do(tt.method, location, tt.body, tt.header)
+		if rec.Code != tt.status {
+			t.Errorf("%v: %v %v, got: %v %s want: %v \n", tt.name, tt.method, location, rec.Code, rec.Body, tt.status)
+		}
+		if got := rec.Header().Get("Upload-Offset"); got != tt.offset {
+			t.Errorf("%v: %v %v, Upload-Offset got: %v want: %v \n", tt.name, tt.method, location, got, tt.offset)
+		}
+	}
+
+	data, err := buck.ReadAll(context.Background(), "uploads/hello.txt")
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("ReadAll(uploads/hello.txt), got: %s (%v) want: hello world \n", data, err)
+	}
+	attrs, err := buck.Attributes(context.Background(), "uploads/hello.txt")
+	if err != nil || attrs.ContentType != "text/plain" {
+		t.Errorf("Attributes(uploads/hello.txt), got: %v (%v) want: text/plain \n", attrs, err)
+	}
+	if objs, _ := buck.List(context.Background(), buck.StagingPrefix()+DefaultStagingPrefix); len(objs) != 1 {
+		t.Errorf("List(%v), got: %v want: 1 (info) \n", buck.StagingPrefix()+DefaultStagingPrefix, len(objs))
+	}
+
+	if rec := do(http.MethodDelete, location, nil, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE %v, got: %v want: %v \n", location, rec.Code, http.StatusNoContent)
+	}
+	if rec := do(http.MethodHead, location, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("HEAD %v, got: %v want: %v \n", location, rec.Code, http.StatusNotFound)
+	}
+	// the ids out of the generated ones are not found, e.g. out of the staging prefix
+	for _, target := range []string{"/files/..", "/files/abc", "/files/" + strings.Repeat("0", 31) + "g", location + "00"} {
+		if rec := do(http.MethodHead, target, nil, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("HEAD %v, got: %v want: %v \n", target, rec.Code, http.StatusNotFound)
+		}
+	}
+	req := httptest.NewRequest(http.MethodHead, location, nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("HEAD %v (no Tus-Resumable), got: %v want: %v \n", location, rec.Code, http.StatusPreconditionFailed)
+	}
+}